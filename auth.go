@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// profile selects which API key to use; "" is the default FAL_KEY
+var profile string
+
+const defaultProfile = "default"
+
+// apiKeyVar returns the variable holding the key for a profile: FAL_KEY for
+// the default, FAL_KEY_WORK for "work"
+func apiKeyVar(name string) string {
+	if name == "" || name == defaultProfile {
+		return "FAL_KEY"
+	}
+	return "FAL_KEY_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// profileName is the inverse of apiKeyVar
+func profileName(key string) string {
+	if key == "FAL_KEY" {
+		return defaultProfile
+	}
+	return strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(key, "FAL_KEY_")), "_", "-")
+}
+
+// envFiles lists the .env files keys are read from, in lookup order
+func envFiles() []string {
+	files := []string{".env"}
+	if genDir := getGenCLIDir(); genDir != "" {
+		files = append(files, filepath.Join(genDir, ".env"))
+	}
+	return files
+}
+
+// lookupAPIKey finds the --profile's key the way getAPIKey does, without
+// exiting, and says where it came from
+func lookupAPIKey() (key, source string) {
+	keyVar := apiKeyVar(profile)
+	if key := os.Getenv(keyVar); key != "" {
+		return key, "environment"
+	}
+	for _, path := range envFiles() {
+		if vars, err := godotenv.Read(path); err == nil && vars[keyVar] != "" {
+			return vars[keyVar], path
+		}
+	}
+	return "", ""
+}
+
+// maskKey hides all but the ends of an API key
+func maskKey(key string) string {
+	if len(key) <= 12 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:4] + strings.Repeat("*", 8) + key[len(key)-4:]
+}
+
+func runAuthList(cmd *cobra.Command, args []string) {
+	type source struct {
+		name string
+		vars map[string]string
+	}
+	sources := []source{{name: "environment", vars: map[string]string{}}}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			sources[0].vars[k] = v
+		}
+	}
+	for _, path := range envFiles() {
+		if vars, err := godotenv.Read(path); err == nil {
+			sources = append(sources, source{name: path, vars: vars})
+		}
+	}
+
+	// The first source to define a key wins, as in getAPIKey
+	found := map[string][2]string{}
+	for _, src := range sources {
+		for k, v := range src.vars {
+			if (k != "FAL_KEY" && !strings.HasPrefix(k, "FAL_KEY_")) || v == "" {
+				continue
+			}
+			if _, ok := found[k]; !ok {
+				found[k] = [2]string{v, src.name}
+			}
+		}
+	}
+	if len(found) == 0 {
+		fmt.Println("No API keys configured. Set FAL_KEY, or FAL_KEY_<PROFILE> for a named profile.")
+		return
+	}
+
+	keys := make([]string, 0, len(found))
+	for k := range found {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROFILE\tKEY\tSOURCE")
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", profileName(k), maskKey(found[k][0]), found[k][1])
+	}
+	w.Flush()
+}
+
+// errKeyRejected means FAL refused the key, as opposed to the check failing
+var errKeyRejected = errors.New("FAL rejected the key")
+
+// verifyAPIKey makes a free authenticated request: a status lookup for a
+// queue request that doesn't exist. FAL checks the key first, so 401 or 403
+// means a bad key and anything else means it was accepted.
+func verifyAPIKey(apiKey string) error {
+	info := models["z-turbo"]
+	client := newFALClient()
+	url := client.queueURL(info, info.GenPath)
+	if url != "" {
+		url += "/requests/00000000-0000-0000-0000-000000000000/status"
+	} else {
+		// Without a queue, a GET of the endpoint is refused just the same
+		url = client.endpointURL(info, info.GenPath)
+	}
+	_, err := doFALOnce(client, apiKey, "GET", url, nil, 30*time.Second)
+
+	var apiErr *APIError
+	if err == nil || !errors.As(err, &apiErr) {
+		return err
+	}
+	if apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%w (%d): %s", errKeyRejected, apiErr.StatusCode, apiErr.Message)
+	}
+	return nil
+}
+
+func runAuthCheck(cmd *cobra.Command, args []string) {
+	apiKey := getAPIKey()
+	name := profile
+	if name == "" {
+		name = defaultProfile
+	}
+	if err := verifyAPIKey(apiKey); err != nil {
+		fatalf("%s key %s: %v", name, maskKey(apiKey), err)
+	}
+	fmt.Printf("✓ %s key %s is valid\n", name, maskKey(apiKey))
+}
+
+// runAuthLogin asks for a key, checks it, and saves it to ~/.gen-cli/.env
+// under the --profile's variable, replacing any previous value
+func runAuthLogin(cmd *cobra.Command, args []string) {
+	genDir := getGenCLIDir()
+	if genDir == "" {
+		fatalf("could not find the home directory")
+	}
+	keyVar := apiKeyVar(profile)
+
+	fmt.Fprintf(os.Stderr, "Paste your FAL API key for %s (from https://fal.ai/dashboard/keys): ", keyVar)
+	var apiKey string
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		data, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			fatalf("failed to read key: %v", err)
+		}
+		apiKey = string(data)
+	} else {
+		apiKey, _ = readLine()
+	}
+	apiKey = strings.TrimSpace(apiKey)
+	if apiKey == "" {
+		fatalf("no key entered")
+	}
+
+	if err := verifyAPIKey(apiKey); errors.Is(err, errKeyRejected) {
+		fatalf("%v; not saved", err)
+	} else if err != nil {
+		warnf("could not verify the key: %v", err)
+	}
+
+	path := filepath.Join(genDir, ".env")
+	if err := setEnvFileVar(path, keyVar, apiKey); err != nil {
+		fatalf("failed to save key: %v", err)
+	}
+	fmt.Printf("Saved %s to %s\n", keyVar, path)
+}
+
+// setEnvFileVar sets key=value in a .env file, replacing an existing line
+// for key and keeping the rest. The file is only readable by its owner.
+func setEnvFileVar(path, key, value string) error {
+	var lines []string
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	replaced := false
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		trimmed := strings.TrimPrefix(strings.TrimSpace(line), "export ")
+		if name, _, ok := strings.Cut(trimmed, "="); ok && strings.TrimSpace(name) == key {
+			if !replaced {
+				lines = append(lines, key+"="+value)
+				replaced = true
+			}
+			continue
+		}
+		if line != "" || len(lines) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	if !replaced {
+		lines = append(lines, key+"="+value)
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file, so tighten it explicitly
+	return os.Chmod(path, 0600)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var batchOutputDir string
+
+// readPromptLines reads one prompt per line, skipping blank lines and # comments
+func readPromptLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var prompts []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		prompts = append(prompts, line)
+	}
+	return prompts, scanner.Err()
+}
+
+// slugify turns a prompt into a short file-name-safe string
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+		if b.Len() >= 40 {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return "image"
+	}
+	return b.String()
+}
+
+func runBatch(opts *genOptions, cmd *cobra.Command, args []string) {
+	recordFlags(cmd)
+	applyConfig(opts, cmd)
+	if err := validateNameTemplate(opts.nameTemplate); err != nil {
+		fatalf("%v", err)
+	}
+	if err := applyAspect(opts, cmd); err != nil {
+		fatalf("%v", err)
+	}
+	if err := checkContactSheet(opts); err != nil {
+		fatalf("%v", err)
+	}
+
+	prompts, err := readPromptLines(args[0])
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if len(prompts) == 0 {
+		fatalf("no prompts in %s", args[0])
+	}
+	if opts.batchConcurrency < 1 {
+		fatalf("--concurrency must be at least 1")
+	}
+
+	normalized, err := normalizeFormat(opts.format)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	opts.format = normalized
+
+	resolvedModel := resolveModel(opts.model)
+	info, ok := models[resolvedModel]
+	if !ok {
+		fatalf("unknown model '%s'. Use 'gen models' to see available options.", opts.model)
+	}
+	if opts.size != "" {
+		if err := validateSize(info, opts.size, false); err != nil {
+			fatalf("model '%s': %v", opts.model, err)
+		}
+	}
+	if info.DefaultTimeout > 0 {
+		opts.requestTimeout = info.DefaultTimeout
+	}
+
+	if opts.outputDir != "" && batchOutputDir != "" {
+		fatalf("--output-dir cannot be combined with -o")
+	}
+	outDir := batchOutputDir
+	if outDir == "" {
+		outDir, err = getDefaultOutputDir(opts)
+		if err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		fatalf("failed to create output directory: %v", err)
+	}
+
+	// Jobs run concurrently, so two lines mustn't share a file name
+	dests := make([]string, len(prompts))
+	seeds := make([]int, len(prompts))
+	seen := make(map[string]int)
+	for i, prompt := range prompts {
+		// Each line gets its own reproducible seed unless one was fixed
+		seeds[i] = opts.seed
+		if opts.seed < 0 && !opts.randomSeed {
+			seeds[i] = rand.IntN(math.MaxInt32)
+		}
+		name := fmt.Sprintf("%03d-%s", i+1, slugify(prompt))
+		if opts.nameTemplate != "" {
+			name = expandNameTemplate(opts.nameTemplate, ImageMetadata{Prompt: prompt, Model: resolvedModel, Seed: seeds[i], Size: opts.size, Format: opts.format, CreatedAt: time.Now()}, i+1)
+		}
+		dest := filepath.Join(outDir, name+"."+opts.format)
+		if other, ok := seen[dest]; ok {
+			fatalf("lines %d and %d would both be saved as %s; add {n} to --name-template to tell them apart", other, i+1, dest)
+		}
+		seen[dest] = i + 1
+		dests[i] = dest
+	}
+
+	if err := confirmCost(opts, resolvedModel, len(prompts)); err != nil {
+		fatalf("%v", err)
+	}
+	apiKey := getAPIKey()
+
+	runJobs(opts, len(prompts), func(i int) string {
+		return truncate(prompts[i], 40)
+	}, func(i int) (jobResult, error) {
+		return runBatchJob(opts, apiKey, resolvedModel, info, prompts[i], dests[i], seeds[i])
+	})
+}
+
+// runJobs runs job for items 0..n-1 on up to --concurrency workers,
+// printing a ✓ or ✗ line for each, labelled by label, and then a summary.
+// The images that were saved go on the --contact-sheet. It exits non-zero
+// if any job failed or, after Ctrl-C, never started.
+func runJobs(opts *genOptions, n int, label func(int) string, job func(int) (jobResult, error)) {
+	// Parallel spinners would overwrite each other
+	if opts.batchConcurrency > 1 {
+		progressEnabled = false
+	}
+
+	var (
+		mu        sync.Mutex
+		failures  []string
+		succeeded int
+		saved     = make([]*jobResult, n)
+		wg        sync.WaitGroup
+	)
+	jobs := make(chan int)
+	for range min(opts.batchConcurrency, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if appCtx.Err() != nil {
+					continue
+				}
+				start := time.Now()
+				result, err := job(i)
+
+				mu.Lock()
+				if err != nil {
+					failures = append(failures, fmt.Sprintf("%d: %s: %v", i+1, label(i), err))
+					infof("[%d/%d] ✗ %s: %v\n", i+1, n, label(i), err)
+				} else if currentLogLevel == levelQuiet {
+					succeeded++
+					saved[i] = &result
+					fmt.Println(result.path)
+				} else {
+					succeeded++
+					saved[i] = &result
+					infof("[%d/%d] ✓ %s (%.1fs)\n", i+1, n, result.path, time.Since(start).Seconds())
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	// After Ctrl-C, the running jobs stop and no new ones start
+	for i := range n {
+		if appCtx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var sheet []jobResult
+	for _, result := range saved {
+		if result != nil {
+			sheet = append(sheet, *result)
+		}
+	}
+
+	skipped := n - succeeded - len(failures)
+	if skipped > 0 {
+		infof("\nBatch interrupted: %d succeeded, %d failed, %d not started\n", succeeded, len(failures), skipped)
+	} else {
+		infof("\nBatch complete: %d succeeded, %d failed\n", succeeded, len(failures))
+	}
+	saveContactSheet(opts, sheet)
+	if len(failures) > 0 || skipped > 0 {
+		for _, f := range failures {
+			fmt.Fprintf(os.Stderr, "  %s\n", f)
+		}
+		os.Exit(1)
+	}
+}
+
+// jobOutputDir returns the directory runJobs outputs go in when the root
+// command runs them: the -o directory, or the default output directory.
+// what names the option that made the run one of several jobs.
+func jobOutputDir(opts *genOptions, what string) (string, error) {
+	if len(opts.outputs) == 0 {
+		return getDefaultOutputDir(opts)
+	}
+	if len(opts.outputs) > 1 || opts.outputs[0] == stdoutDest || isRemoteDest(opts.outputs[0]) {
+		return "", fmt.Errorf("with %s, -o must be a single local directory", what)
+	}
+	return opts.outputs[0], nil
+}
+
+// runBatchJob generates a single batch prompt and saves it to dest. A
+// negative seed lets the server choose.
+func runBatchJob(opts *genOptions, apiKey, resolvedModel string, info ModelInfo, prompt, dest string, jobSeed int) (jobResult, error) {
+	sizeValue := resolveSizeValue(opts, info, opts.size, false)
+	req, err := buildRequest(opts, info, opts.model, prompt, sizeValue, nil)
+	if err != nil {
+		return jobResult{}, err
+	}
+	if jobSeed >= 0 {
+		req.Seed = &jobSeed
+	}
+
+	meta := ImageMetadata{
+		Prompt:    prompt,
+		Model:     resolvedModel,
+		ModelPath: info.GenPath,
+		Size:      sizeValue,
+		Format:    opts.format,
+	}
+	path, err := runJobRequest(opts, apiKey, info, info.GenPath, req, &meta, dest)
+	if err != nil {
+		return jobResult{}, err
+	}
+	return jobResult{path: path, caption: seedCaption(resolvedModel, meta.Seed)}, nil
+}
+
+// runJobRequest sends req for one runJobs job and saves the first image
+// to dest, recording it in history. It fills in meta's seed and time, and
+// returns the saved path.
+func runJobRequest(opts *genOptions, apiKey string, info ModelInfo, modelPath string, req ImageRequest, meta *ImageMetadata, dest string) (string, error) {
+	start := time.Now()
+	response, err := callFALAPI(opts.falClient(), apiKey, info, modelPath, req)
+	if err != nil {
+		return "", err
+	}
+	elapsed := time.Since(start)
+	if len(response.Images) == 0 {
+		return "", errors.New("no images returned")
+	}
+	warnFlagged(opts, info, response)
+
+	meta.Seed = response.Seed
+	meta.CreatedAt = time.Now()
+	saved, err := saveOutputs(opts, response.Images[0].URL, []string{dest}, opts.format, meta.Model, "", "", meta)
+	if err != nil {
+		return "", fmt.Errorf("%w (image still at %s)", err, response.Images[0].URL)
+	}
+	defer saved.cleanup()
+
+	if err := appendHistory(HistoryEntry{ImageMetadata: *meta, Outputs: saved.Destinations, Count: 1, ElapsedSeconds: elapsed.Seconds()}); err != nil {
+		warnf("could not update history: %v", err)
+	}
+	return saved.Destinations[0], nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	cleanOlderThan string
+	cleanKeep      int
+)
+
+// generatedNamePattern matches the default names of auto-named images
+var generatedNamePattern = regexp.MustCompile(`^generated_\d+.*\.(png|jpe?g|webp)$`)
+
+// cleanCandidate is a generated image in the output directory
+type cleanCandidate struct {
+	path    string
+	modTime time.Time
+	files   []string // The image and any .json/.seed sidecars
+	size    int64
+}
+
+// parseAge reads a duration like 7d, 12h, or 30m
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age '%s' (use e.g. 7d, 12h, or 30m)", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age '%s' (use e.g. 7d, 12h, or 30m)", s)
+	}
+	return d, nil
+}
+
+// runClean deletes generated images from the output directory. Only files
+// with the default generated_ name or recorded in history are considered,
+// so images saved there by hand are never touched.
+func runClean(opts *genOptions, cmd *cobra.Command, args []string) {
+	var maxAge time.Duration
+	if cleanOlderThan != "" {
+		var err error
+		if maxAge, err = parseAge(cleanOlderThan); err != nil {
+			fatalf("--older-than: %v", err)
+		}
+	}
+	if cleanKeep < 0 {
+		fatalf("--keep must not be negative")
+	}
+
+	dir, err := getDefaultOutputDir(opts)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	tracked := map[string]bool{}
+	entries, err := readHistory()
+	if err != nil {
+		fatalf("failed to read history: %v", err)
+	}
+	for _, entry := range entries {
+		for _, out := range entry.Outputs {
+			if abs, err := filepath.Abs(out); err == nil {
+				tracked[abs] = true
+			}
+		}
+	}
+
+	var candidates []cleanCandidate
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		abs, _ := filepath.Abs(path)
+		if !generatedNamePattern.MatchString(d.Name()) && !tracked[abs] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		c := cleanCandidate{path: path, modTime: info.ModTime(), files: []string{path}, size: info.Size()}
+		stem := strings.TrimSuffix(path, filepath.Ext(path))
+		for _, sidecar := range []string{stem + ".json", stem + ".seed"} {
+			if info, err := os.Stat(sidecar); err == nil && info.Mode().IsRegular() {
+				c.files = append(c.files, sidecar)
+				c.size += info.Size()
+			}
+		}
+		candidates = append(candidates, c)
+		return nil
+	})
+	if err != nil {
+		fatalf("failed to scan %s: %v", dir, err)
+	}
+
+	// Newest first, so --keep spares the most recent images
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].modTime.After(candidates[j].modTime) })
+	var remove []cleanCandidate
+	for i, c := range candidates {
+		if i < cleanKeep || (maxAge > 0 && time.Since(c.modTime) < maxAge) {
+			continue
+		}
+		remove = append(remove, c)
+	}
+	if len(remove) == 0 {
+		infof("Nothing to clean in %s\n", dir)
+		return
+	}
+
+	var total int64
+	for _, c := range remove {
+		total += c.size
+	}
+	if opts.dryRun {
+		for _, c := range remove {
+			fmt.Println(c.path)
+		}
+		infof("Would delete %d images (%s) from %s\n", len(remove), formatBytes(total), dir)
+		return
+	}
+	if !assumeYes {
+		fmt.Fprintf(os.Stderr, "Delete %d images (%s) from %s? [y/N] ", len(remove), formatBytes(total), dir)
+		answer, _ := readLine()
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fatalf("cancelled")
+		}
+	}
+
+	var reclaimed int64
+	deleted := 0
+	for _, c := range remove {
+		failed := false
+		for _, f := range c.files {
+			info, statErr := os.Stat(f)
+			if err := os.Remove(f); err != nil {
+				warnf("could not delete %s: %v", f, err)
+				failed = true
+			} else if statErr == nil {
+				reclaimed += info.Size()
+			}
+		}
+		if !failed {
+			deleted++
+			debugf("Deleted %s\n", c.path)
+		}
+	}
+	infof("Deleted %d images, reclaimed %s\n", deleted, formatBytes(reclaimed))
+}
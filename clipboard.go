@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// copyImageToClipboard puts the image at path on the system clipboard using
+// whichever clipboard tool the platform provides
+func copyImageToClipboard(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	mimeType := "image/" + strings.TrimPrefix(strings.ToLower(filepath.Ext(abs)), ".")
+	if mimeType == "image/jpg" {
+		mimeType = "image/jpeg"
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		class := "«class PNGf»"
+		if mimeType == "image/jpeg" {
+			class = "«class JPEG»"
+		}
+		script := fmt.Sprintf("set the clipboard to (read (POSIX file %q) as %s)", abs, class)
+		return runClipboardTool("", "osascript", "-e", script)
+	case "windows":
+		script := fmt.Sprintf("Add-Type -AssemblyName System.Windows.Forms; "+
+			"[System.Windows.Forms.Clipboard]::SetImage([System.Drawing.Image]::FromFile('%s'))",
+			strings.ReplaceAll(abs, "'", "''"))
+		return runClipboardTool("", "powershell", "-NoProfile", "-STA", "-Command", script)
+	}
+
+	// Prefer wl-copy under Wayland, falling back to xclip for X11
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		if _, err := exec.LookPath("wl-copy"); err == nil {
+			return runClipboardTool(abs, "wl-copy", "--type", mimeType)
+		}
+	}
+	if _, err := exec.LookPath("xclip"); err == nil {
+		return runClipboardTool("", "xclip", "-selection", "clipboard", "-t", mimeType, "-i", abs)
+	}
+	if _, err := exec.LookPath("wl-copy"); err == nil {
+		return runClipboardTool(abs, "wl-copy", "--type", mimeType)
+	}
+	return errors.New("no clipboard tool found (install wl-clipboard or xclip)")
+}
+
+// runClipboardTool runs a clipboard command, feeding it the file at stdin
+// if one is given
+func runClipboardTool(stdin, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if stdin != "" {
+		f, err := os.Open(stdin)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		cmd.Stdin = f
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %s", name, msg)
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
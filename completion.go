@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// registerCompletions adds dynamic completion for the model, size, and
+// format flags of a command that has them
+func registerCompletions(cmd *cobra.Command) {
+	cmd.RegisterFlagCompletionFunc("model", completeModels)
+	cmd.RegisterFlagCompletionFunc("size", completeSizes)
+	cmd.RegisterFlagCompletionFunc("aspect", completeSizes)
+	cmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions(outputFormats, cobra.ShellCompDirectiveNoFileComp))
+	cmd.RegisterFlagCompletionFunc("also", cobra.FixedCompletions(outputFormats, cobra.ShellCompDirectiveNoFileComp))
+	cmd.RegisterFlagCompletionFunc("png-compression", cobra.FixedCompletions([]string{"none", "fast", "default", "best"}, cobra.ShellCompDirectiveNoFileComp))
+}
+
+// completeModels suggests model names and aliases, including custom models
+func completeModels(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	// Completion doesn't run PersistentPreRun, so load custom models here
+	_ = loadCustomModels()
+
+	var names []string
+	for name, info := range models {
+		names = append(names, name+"\t"+info.GenPath)
+	}
+	for alias, target := range modelAliases {
+		names = append(names, alias+"\talias for "+target)
+	}
+	sort.Strings(names)
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeSizes suggests the ratios the chosen model accepts (any model's
+// if -m isn't given yet), completing the last entry of a comma list
+func completeSizes(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	ratios := map[string]bool{"auto": true}
+	for ratio := range ratioToPreset {
+		ratios[ratio] = true
+	}
+	for _, ratio := range customSizeRatios {
+		ratios[ratio] = true
+	}
+	if name, err := cmd.Flags().GetString("model"); err == nil && name != "" {
+		_ = loadCustomModels()
+		if info, ok := models[resolveModel(name)]; ok && info.SizeParamName == "aspect_ratio" {
+			ratios = aspectRatioSupported
+		}
+	} else {
+		for ratio := range aspectRatioSupported {
+			ratios[ratio] = true
+		}
+	}
+
+	prefix := ""
+	if i := strings.LastIndex(toComplete, ","); i >= 0 {
+		prefix = toComplete[:i+1]
+	}
+	var sizes []string
+	for ratio := range ratios {
+		sizes = append(sizes, prefix+ratio)
+	}
+	sort.Strings(sizes)
+	return sizes, cobra.ShellCompDirectiveNoFileComp
+}
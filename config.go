@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds defaults read from ~/.gen-cli/config.yaml:
+//
+//	model: flux2-pro
+//	size: "16:9"
+//	format: jpeg
+//	output_dir: ~/Pictures/gen
+//	name_template: "{date}_{model}_{slug}"
+//	seed: 42
+//	prices:
+//	  flux2-pro: 0.03
+//	confirm: true
+//	confirm_above: 0.50
+//	update_check: true
+//
+// Command-line flags override these, which override the built-in defaults.
+type Config struct {
+	Model        string `yaml:"model"`
+	Size         string `yaml:"size"`
+	Format       string `yaml:"format"`
+	OutputDir    string `yaml:"output_dir"` // Directory for auto-named images
+	Output       string `yaml:"output"`     // Older name for output_dir
+	NameTemplate string `yaml:"name_template"`
+	Seed         *int   `yaml:"seed"`
+
+	Prices       map[string]float64 `yaml:"prices"`        // USD per image, overriding the built-in estimates
+	Confirm      bool               `yaml:"confirm"`       // Like --confirm on every run
+	ConfirmAbove float64            `yaml:"confirm_above"` // USD; --confirm only asks above this
+
+	UpdateCheck bool `yaml:"update_check"` // Check GitHub daily for a newer release
+}
+
+// config is loaded once at startup; the zero value means no config file
+var config Config
+
+// loadConfig reads the config file if it exists. A missing file is not an
+// error; a malformed one, including unknown keys, is.
+func loadConfig() (Config, error) {
+	var cfg Config
+	genDir := getGenCLIDir()
+	if genDir == "" {
+		return cfg, nil
+	}
+	path := filepath.Join(genDir, "config.yaml")
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if cfg.OutputDir == "" {
+		cfg.OutputDir = cfg.Output
+	}
+	cfg.OutputDir = expandHome(cfg.OutputDir)
+	return cfg, nil
+}
+
+// applyConfig fills generation flags that weren't set on the command line
+// from the config file
+func applyConfig(opts *genOptions, cmd *cobra.Command) {
+	flags := cmd.Flags()
+	if config.Model != "" && !flags.Changed("model") {
+		opts.model = config.Model
+	}
+	if config.Size != "" && !flags.Changed("size") {
+		opts.size = config.Size
+	}
+	if config.Format != "" && !flags.Changed("format") {
+		opts.format = config.Format
+	}
+	// --random-seed overrides a seed pinned in the config
+	if config.Seed != nil && !flags.Changed("seed") && !opts.randomSeed {
+		opts.seed = *config.Seed
+	}
+	if config.NameTemplate != "" && !flags.Changed("name-template") {
+		opts.nameTemplate = config.NameTemplate
+	}
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Contact sheet layout, in pixels
+const (
+	sheetTile    = 256 // Images are scaled to fit a square of this size
+	sheetPadding = 8
+	sheetCaption = 18 // Height of the caption strip under each image
+)
+
+// jobResult is a saved image and the caption it gets on a contact sheet
+type jobResult struct {
+	path    string
+	caption string
+}
+
+// seedCaption describes an image by its model and seed
+func seedCaption(model string, seed int) string {
+	return fmt.Sprintf("%s  seed %d", model, seed)
+}
+
+// checkContactSheet rejects a --contact-sheet path that won't hold a PNG
+func checkContactSheet(opts *genOptions) error {
+	if opts.contactSheet == "" {
+		return nil
+	}
+	if !strings.EqualFold(filepath.Ext(opts.contactSheet), ".png") {
+		return fmt.Errorf("--contact-sheet writes a PNG; give it a .png path, not '%s'", opts.contactSheet)
+	}
+	return nil
+}
+
+// resultImages lists the local files of generation results for a contact
+// sheet, captioned with their model and seed
+func resultImages(results []*GenerationResult) []jobResult {
+	var images []jobResult
+	for _, result := range results {
+		for _, img := range result.Images {
+			for _, dest := range img.Destinations {
+				if dest != "stdout" && !isRemoteDest(dest) {
+					images = append(images, jobResult{path: dest, caption: seedCaption(result.Model, result.Seed)})
+					break
+				}
+			}
+		}
+	}
+	return images
+}
+
+// writeContactSheet tiles images into a captioned grid PNG at path. The
+// grid is as close to square as the count allows.
+func writeContactSheet(path string, images []jobResult, pngLevel png.CompressionLevel) error {
+	if len(images) == 0 {
+		return errors.New("no saved images to put on it")
+	}
+	cols := int(math.Ceil(math.Sqrt(float64(len(images)))))
+	rows := (len(images) + cols - 1) / cols
+	cellW, cellH := sheetTile+sheetPadding, sheetTile+sheetCaption+sheetPadding
+	sheet := image.NewRGBA(image.Rect(0, 0, cols*cellW+sheetPadding, rows*cellH+sheetPadding))
+	draw.Draw(sheet, sheet.Bounds(), image.White, image.Point{}, draw.Src)
+
+	face := basicfont.Face7x13
+	maxChars := sheetTile / face.Advance
+	for i, entry := range images {
+		img, _, err := decodeImageFile(entry.path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.path, err)
+		}
+		x := sheetPadding + (i%cols)*cellW
+		y := sheetPadding + (i/cols)*cellH
+
+		// Fit the image in its tile, centered, keeping its aspect ratio
+		b := img.Bounds()
+		scale := min(float64(sheetTile)/float64(b.Dx()), float64(sheetTile)/float64(b.Dy()))
+		w, h := max(1, int(float64(b.Dx())*scale)), max(1, int(float64(b.Dy())*scale))
+		dst := image.Rect(x+(sheetTile-w)/2, y+(sheetTile-h)/2, x+(sheetTile-w)/2+w, y+(sheetTile-h)/2+h)
+		draw.CatmullRom.Scale(sheet, dst, img, b, draw.Over, nil)
+
+		caption := entry.caption
+		if len(caption) > maxChars {
+			caption = caption[:maxChars-3] + "..."
+		}
+		d := font.Drawer{
+			Dst:  sheet,
+			Src:  image.NewUniform(color.Gray{Y: 64}),
+			Face: face,
+			Dot:  fixed.P(x, y+sheetTile+face.Ascent+3),
+		}
+		d.DrawString(caption)
+	}
+
+	data, err := encodeImage(sheet, "png", 0, pngLevel)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// saveContactSheet writes the --contact-sheet, if one was asked for, and
+// reports where it went
+func saveContactSheet(opts *genOptions, images []jobResult) {
+	if opts.contactSheet == "" {
+		return
+	}
+	if err := writeContactSheet(opts.contactSheet, images, opts.pngLevel()); err != nil {
+		warnf("could not write the contact sheet: %v", err)
+		return
+	}
+	infof("Contact sheet: %s\n", opts.contactSheet)
+}
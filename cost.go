@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// estimatedPrice returns the approximate USD per image for a model. Prices
+// in the config file take precedence over the built-in estimates.
+func estimatedPrice(name string) float64 {
+	if price, ok := config.Prices[name]; ok {
+		return price
+	}
+	return models[name].PricePerImage
+}
+
+// formatPrice renders a USD amount, keeping a third decimal for sub-cent prices
+func formatPrice(usd float64) string {
+	if usd < 0.01 {
+		return fmt.Sprintf("$%.3f", usd)
+	}
+	return fmt.Sprintf("$%.2f", usd)
+}
+
+// confirmCost prints the estimated cost of generating images with the model
+// and, with --confirm, asks before spending more than confirm_above. The
+// estimate is advisory; FAL's pricing may differ.
+func confirmCost(opts *genOptions, name string, images int) error {
+	return confirmCosts(opts, map[string]int{name: images})
+}
+
+// confirmCosts is confirmCost for a run that uses several models, given
+// the number of images from each
+func confirmCosts(opts *genOptions, images map[string]int) error {
+	var cost float64
+	for name, n := range images {
+		cost += estimatedPrice(name) * float64(n)
+	}
+	if cost == 0 {
+		return nil
+	}
+
+	// Every similarity attempt is a paid generation
+	qualifier := ""
+	if opts.targetSimilarity != "" {
+		cost *= float64(opts.similarityAttempts)
+		qualifier = "up to "
+	}
+	infof("Estimated cost: %s%s\n", qualifier, formatPrice(cost))
+
+	if !(opts.confirm || config.Confirm) || assumeYes || opts.dryRun || cost <= config.ConfirmAbove {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Continue? [y/N] ")
+	answer, _ := readLine()
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("cancelled")
+}
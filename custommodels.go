@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// customModel is an entry in ~/.gen-cli/models.yaml:
+//
+//	recraft:
+//	  gen_path: fal-ai/recraft/v3/text-to-image
+//	  size_param_name: image_size
+//
+// Entries are added to models at startup and replace built-ins of the same name.
+type customModel struct {
+	GenPath             string `yaml:"gen_path"`
+	EditPath            string `yaml:"edit_path"`
+	InpaintPath         string `yaml:"inpaint_path"`
+	SupportsAutoImgSize bool   `yaml:"supports_auto_img_size"`
+	SizeParamName       string `yaml:"size_param_name"` // Defaults to image_size
+	ExpandPromptParam   string `yaml:"expand_prompt_param"`
+
+	// Endpoint quirks for models not served from fal.run
+	BaseURL        string `yaml:"base_url"`
+	QueueBaseURL   string `yaml:"queue_base_url"`
+	EndpointSuffix string `yaml:"endpoint_suffix"`
+	SyncOnly       bool   `yaml:"sync_only"`
+
+	SupportsNegativePrompt bool `yaml:"supports_negative_prompt"`
+	SupportsSteps          bool `yaml:"supports_steps"`
+	SupportsStrength       bool `yaml:"supports_strength"`
+	SupportsImg2Img        bool `yaml:"supports_img2img"`
+	SupportsSafetyChecker  bool `yaml:"supports_safety_checker"`
+	SupportsImageWeights   bool `yaml:"supports_image_weights"`
+	UsesImageRefs          bool `yaml:"uses_image_refs"`
+	SupportsHexColors      bool `yaml:"supports_hex_colors"`
+
+	DefaultSize        string        `yaml:"default_size"` // Defaults to 4:3
+	DefaultTimeout     time.Duration `yaml:"default_timeout"`
+	MaxSafetyTolerance int           `yaml:"max_safety_tolerance"`
+	MaxImages          int           `yaml:"max_images"`
+	MaxMegapixels      float64       `yaml:"max_megapixels"`
+	PricePerImage      float64       `yaml:"price_per_image"`
+}
+
+// loadCustomModels merges the user's models file into models. A missing
+// file is not an error.
+func loadCustomModels() error {
+	genDir := getGenCLIDir()
+	if genDir == "" {
+		return nil
+	}
+	path := filepath.Join(genDir, "models.yaml")
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var custom map[string]customModel
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&custom); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	// Sorted so the first problem reported is stable
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		m := custom[name]
+		if m.GenPath == "" {
+			return fmt.Errorf("%s: model '%s' is missing gen_path", path, name)
+		}
+		switch m.SizeParamName {
+		case "":
+			m.SizeParamName = "image_size"
+		case "image_size", "aspect_ratio":
+		default:
+			return fmt.Errorf("%s: model '%s' has invalid size_param_name '%s' (valid: image_size, aspect_ratio)", path, name, m.SizeParamName)
+		}
+		if m.DefaultTimeout < 0 || m.MaxSafetyTolerance < 0 {
+			return fmt.Errorf("%s: model '%s' has a negative default_timeout or max_safety_tolerance", path, name)
+		}
+		if m.ExpandPromptParam != "" && !slices.Contains(expandPromptParams, m.ExpandPromptParam) {
+			return fmt.Errorf("%s: model '%s' has invalid expand_prompt_param '%s' (valid: %s)", path, name, m.ExpandPromptParam, strings.Join(expandPromptParams, ", "))
+		}
+
+		models[name] = ModelInfo{
+			GenPath:             m.GenPath,
+			EditPath:            m.EditPath,
+			InpaintPath:         m.InpaintPath,
+			SupportsAutoImgSize: m.SupportsAutoImgSize,
+			SizeParamName:       m.SizeParamName,
+			ExpandPromptParam:   m.ExpandPromptParam,
+
+			BaseURL:        m.BaseURL,
+			QueueBaseURL:   m.QueueBaseURL,
+			EndpointSuffix: m.EndpointSuffix,
+			SyncOnly:       m.SyncOnly,
+
+			MaxSafetyTolerance:     m.MaxSafetyTolerance,
+			SupportsNegativePrompt: m.SupportsNegativePrompt,
+			SupportsSteps:          m.SupportsSteps,
+			SupportsStrength:       m.SupportsStrength,
+			SupportsImg2Img:        m.SupportsImg2Img,
+			SupportsSafetyChecker:  m.SupportsSafetyChecker,
+			SupportsImageWeights:   m.SupportsImageWeights,
+			UsesImageRefs:          m.UsesImageRefs,
+			SupportsHexColors:      m.SupportsHexColors,
+			MaxImages:              m.MaxImages,
+			MaxMegapixels:          m.MaxMegapixels,
+			PricePerImage:          m.PricePerImage,
+			DefaultSize:            m.DefaultSize,
+			DefaultTimeout:         m.DefaultTimeout,
+			Custom:                 true,
+		}
+		if m.DefaultSize != "" {
+			if err := validateSize(models[name], m.DefaultSize, false); err != nil {
+				return fmt.Errorf("%s: model '%s' has invalid default_size: %w", path, name, err)
+			}
+		}
+		// A custom model also shadows a built-in alias of the same name
+		delete(modelAliases, name)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// failure collects context for --save-on-error as a run progresses
+var failure struct {
+	flags   map[string]string
+	request any
+}
+
+// dataURIPattern matches base64 data URIs inside JSON strings
+var dataURIPattern = regexp.MustCompile(`"data:([^;"]+);base64,[A-Za-z0-9+/=]+"`)
+
+// recordFlags remembers the flags explicitly set on the command line
+func recordFlags(cmd *cobra.Command) {
+	failure.flags = map[string]string{}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		failure.flags[f.Name] = f.Value.String()
+	})
+}
+
+// recordRequest remembers the request about to be sent to the API
+func recordRequest(req any) {
+	failure.request = req
+}
+
+// fatalf prints an error and exits, also writing it to stdout as JSON under
+// --json. With --save-on-error it first writes a
+// debug bundle; if any argument is an error wrapping *APIError, the bundle
+// includes its status code, request id, and raw body.
+func fatalf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+	if jsonOutput {
+		printJSON(map[string]string{"error": msg})
+	}
+
+	if saveOnError != "" {
+		var apiErr *APIError
+		for _, arg := range args {
+			if err, ok := arg.(error); ok && errors.As(err, &apiErr) {
+				break
+			}
+		}
+		dir, err := writeDebugBundle(saveOnError, msg, apiErr)
+		if err != nil {
+			warnf("could not save debug bundle: %v", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Debug bundle saved to: %s\n", dir)
+		}
+	}
+	os.Exit(1)
+}
+
+// writeDebugBundle writes everything needed to reproduce a failure into a
+// new timestamped directory under root and returns its path
+func writeDebugBundle(root, msg string, apiErr *APIError) (string, error) {
+	dir := filepath.Join(root, fmt.Sprintf("gen-error-%s", time.Now().Format("20060102-150405")))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	info := map[string]any{
+		"error":      msg,
+		"version":    version,
+		"go_version": runtime.Version(),
+		"platform":   runtime.GOOS + "/" + runtime.GOARCH,
+		"flags":      failure.flags,
+		"time":       time.Now().Format(time.RFC3339),
+	}
+	if apiErr != nil {
+		info["status_code"] = apiErr.StatusCode
+		info["request_id"] = apiErr.RequestID
+	}
+	if err := writeJSONFile(filepath.Join(dir, "info.json"), info); err != nil {
+		return "", err
+	}
+
+	if failure.request != nil {
+		data, err := json.MarshalIndent(failure.request, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		// Input images can be megabytes of base64; keep only the media type
+		data = dataURIPattern.ReplaceAll(data, []byte(`"data:$1;base64,<elided>"`))
+		if err := os.WriteFile(filepath.Join(dir, "request.json"), data, 0644); err != nil {
+			return "", err
+		}
+	}
+
+	if apiErr != nil && len(apiErr.Body) > 0 {
+		if err := os.WriteFile(filepath.Join(dir, "response.txt"), apiErr.Body, 0644); err != nil {
+			return "", err
+		}
+	}
+
+	return dir, nil
+}
+
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+// checkWritable confirms a file can be created in dir
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, ".gen-doctor-")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// runDoctor checks the setup and prints a pass/fail line for each part,
+// with a hint for anything that failed
+func runDoctor(opts *genOptions, cmd *cobra.Command, args []string) {
+	failed := 0
+	report := func(err error, ok, hint string) {
+		if err == nil {
+			fmt.Printf("✓ %s\n", ok)
+			return
+		}
+		failed++
+		fmt.Printf("✗ %v\n    %s\n", err, hint)
+	}
+
+	fmt.Printf("✓ %s\n", versionString())
+
+	keyVar := apiKeyVar(profile)
+	apiKey, source := lookupAPIKey()
+	if apiKey == "" {
+		report(fmt.Errorf("%s is not set", keyVar), "",
+			fmt.Sprintf("Run 'gen auth login', or set %s in the environment or ~/.gen-cli/.env", keyVar))
+	} else {
+		report(nil, fmt.Sprintf("%s %s found in %s", keyVar, maskKey(apiKey), source), "")
+	}
+
+	genDir := getGenCLIDir()
+	if genDir == "" {
+		report(errors.New("could not find the home directory"), "", "Set HOME, or use --output-dir and FAL_KEY in the environment")
+	} else {
+		err := checkWritable(genDir)
+		if err != nil {
+			err = fmt.Errorf("%s is not writable: %w", genDir, err)
+		}
+		report(err, genDir+" is writable", "Fix its permissions; config, keys, and history are kept there")
+
+		_, err = os.Stat(filepath.Join(genDir, "config.yaml"))
+		if err == nil {
+			_, err = loadConfig()
+		} else if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		report(err, "Config file is valid (or absent)", "Fix or remove "+filepath.Join(genDir, "config.yaml"))
+	}
+
+	dir, err := getDefaultOutputDir(opts)
+	if err == nil {
+		if err = checkWritable(dir); err != nil {
+			err = fmt.Errorf("output directory %s is not writable: %w", dir, err)
+		}
+	}
+	report(err, fmt.Sprintf("Output directory %s is writable", dir), "Pick another with --output-dir, GEN_CLI_OUTPUT_DIR, or output_dir in the config")
+
+	// The key check doubles as the network check: it reaches FAL either way
+	if apiKey != "" {
+		err := verifyAPIKey(apiKey)
+		if errors.Is(err, errKeyRejected) {
+			report(err, "", "Create a new key at https://fal.ai/dashboard/keys and run 'gen auth login'")
+		} else if err != nil {
+			report(fmt.Errorf("could not reach FAL: %w", err), "", "Check your network connection, or set --proxy / HTTPS_PROXY")
+		} else {
+			report(nil, "FAL is reachable and accepts the key", "")
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("\n%d problem(s) found\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("\nEverything looks good")
+}
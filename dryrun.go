@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// printDryRun prints the endpoint and request body for each size without
+// calling the API. Input images are elided to their media type.
+func printDryRun(opts *genOptions, info ModelInfo, name, modelPath, prompt string, sizes []string, imageURLs []string) error {
+	isEditMode := len(imageURLs) > 0
+	for i, sz := range sizes {
+		sizeValue := resolveSizeValue(opts, info, sz, isEditMode)
+		req, err := buildRequest(opts, info, name, prompt, sizeValue, imageURLs)
+		if err != nil {
+			return err
+		}
+
+		client := opts.falClient()
+		endpoint := client.queueURL(info, modelPath)
+		if client.sync || endpoint == "" {
+			endpoint = client.endpointURL(info, modelPath)
+		}
+
+		data, err := json.MarshalIndent(req, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = dataURIPattern.ReplaceAll(data, []byte(`"data:$1;base64,<elided>"`))
+
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("POST %s\n%s\n", endpoint, data)
+	}
+	return nil
+}
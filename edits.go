@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// inputImageExts are the files a directory -i picks up
+var inputImageExts = []string{".png", ".jpg", ".jpeg", ".webp"}
+
+// isInputPattern reports whether an -i value is a glob or a directory,
+// which runs the edit once per matching file
+func isInputPattern(input string) bool {
+	if isURL(input) {
+		return false
+	}
+	if strings.ContainsAny(input, "*?[") {
+		return true
+	}
+	stat, err := os.Stat(input)
+	return err == nil && stat.IsDir()
+}
+
+// expandInputs expands a glob or directory -i into its image files, sorted.
+// It returns nil when no -i is a pattern.
+func expandInputs(inputs []string) ([]string, error) {
+	var patterns int
+	for _, input := range inputs {
+		if isInputPattern(input) {
+			patterns++
+		}
+	}
+	if patterns == 0 {
+		return nil, nil
+	}
+	if patterns < len(inputs) {
+		return nil, errors.New("a glob or directory -i cannot be combined with other -i images")
+	}
+
+	var files []string
+	for _, input := range inputs {
+		var matches []string
+		if stat, err := os.Stat(input); err == nil && stat.IsDir() {
+			entries, err := os.ReadDir(input)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				ext := strings.ToLower(filepath.Ext(entry.Name()))
+				if !entry.IsDir() && slices.Contains(inputImageExts, ext) {
+					matches = append(matches, filepath.Join(input, entry.Name()))
+				}
+			}
+		} else {
+			globbed, err := filepath.Glob(input)
+			if err != nil {
+				return nil, fmt.Errorf("invalid -i pattern '%s': %w", input, err)
+			}
+			for _, match := range globbed {
+				if stat, err := os.Stat(match); err == nil && !stat.IsDir() {
+					matches = append(matches, match)
+				}
+			}
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no images match -i '%s'", input)
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return slices.Compact(files), nil
+}
+
+// runEditEach runs the prompt as a separate edit of each file, on up to
+// --concurrency at once, saving every result under its input's name
+func runEditEach(opts *genOptions, apiKey, prompt string, files []string) {
+	switch {
+	case opts.maskPath != "":
+		fatalf("--mask cannot be used with a glob or directory -i")
+	case opts.thenPrompt != "":
+		fatalf("--then cannot be used with a glob or directory -i")
+	case jsonOutput || opts.urlOnly || opts.rawOutput:
+		fatalf("--json, --url-only, and --raw cannot be used with a glob or directory -i")
+	case opts.numImages > 1 || opts.seedSweep > 0:
+		fatalf("--num and --seed-sweep cannot be used with a glob or directory -i")
+	case len(splitList(opts.size)) > 1:
+		fatalf("a glob or directory -i takes a single --size")
+	case opts.batchConcurrency < 1:
+		fatalf("--concurrency must be at least 1")
+	}
+
+	resolvedModel := resolveModel(opts.model)
+	info, ok := models[resolvedModel]
+	if !ok {
+		fatalf("unknown model '%s'. Use 'gen models' to see available options.", opts.model)
+	}
+	if opts.size != "" {
+		if err := validateSize(info, opts.size, true); err != nil {
+			fatalf("model '%s': %v", opts.model, err)
+		}
+	}
+	modelPath, err := modelPathFor(opts, info, opts.model, true)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if opts.fitInputs && info.MaxMegapixels == 0 {
+		if err := ignoredFlag("model '%s' has no input size limit for --fit to meet", opts.model); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if opts.timeoutSet {
+		opts.requestTimeout = opts.timeout
+	} else if info.DefaultTimeout > 0 {
+		opts.requestTimeout = info.DefaultTimeout
+	}
+
+	outDir, err := jobOutputDir(opts, "a glob or directory -i")
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	// Results are named after their inputs, so two inputs mustn't share a name
+	dests := make([]string, len(files))
+	seeds := make([]int, len(files))
+	seen := make(map[string]string)
+	for i, file := range files {
+		// Each file gets its own reproducible seed unless one was fixed
+		seeds[i] = opts.seed
+		if opts.seed < 0 && !opts.randomSeed {
+			seeds[i] = rand.IntN(math.MaxInt32)
+		}
+		name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		if opts.nameTemplate != "" {
+			name = expandNameTemplate(opts.nameTemplate, ImageMetadata{Prompt: prompt, Model: resolvedModel, Seed: seeds[i], Size: opts.size, Format: opts.format, CreatedAt: time.Now()}, i+1)
+		}
+		dest := filepath.Join(outDir, name+"."+opts.format)
+		if absPaths([]string{dest})[0] == absPaths([]string{file})[0] {
+			dest = filepath.Join(outDir, name+"_edit."+opts.format)
+		}
+		if other, ok := seen[dest]; ok {
+			fatalf("%s and %s would both be saved as %s; use --name-template to tell them apart", other, file, dest)
+		}
+		seen[dest] = file
+		dests[i] = dest
+	}
+
+	if opts.translate {
+		translated, err := translatePrompt(opts, apiKey, prompt)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if translated != prompt {
+			infof("Original prompt: %s\n", prompt)
+			infof("Translated prompt: %s\n", translated)
+			prompt = translated
+		}
+	}
+
+	if err := confirmCost(opts, resolvedModel, len(files)); err != nil {
+		fatalf("%v", err)
+	}
+	if opts.dryRun {
+		fmt.Printf("Would edit %d images with %s (%s):\n", len(files), resolvedModel, modelPath)
+		for i, file := range files {
+			fmt.Printf("  %s -> %s\n", file, dests[i])
+		}
+		return
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		fatalf("failed to create output directory: %v", err)
+	}
+
+	runJobs(opts, len(files), func(i int) string {
+		return filepath.Base(files[i])
+	}, func(i int) (jobResult, error) {
+		return runEditJob(opts, apiKey, resolvedModel, info, modelPath, prompt, files[i], dests[i], seeds[i])
+	})
+}
+
+// runEditJob edits a single file for runEditEach and saves it to dest.
+// A negative seed lets the server choose.
+func runEditJob(opts *genOptions, apiKey, resolvedModel string, info ModelInfo, modelPath, prompt, file, dest string, jobSeed int) (jobResult, error) {
+	scale := 1.0
+	if opts.fitInputs && info.MaxMegapixels > 0 {
+		total, err := inputMegapixels([]string{file})
+		if err != nil {
+			return jobResult{}, err
+		}
+		if total > info.MaxMegapixels {
+			scale = math.Sqrt(info.MaxMegapixels/total) * 0.999
+		}
+	}
+	if err := checkInputLimits(info, resolvedModel, []string{file}, scale); err != nil {
+		return jobResult{}, err
+	}
+	var imageURL string
+	var err error
+	if scale < 1 {
+		imageURL, err = fittedImageDataURI(file, scale)
+	} else {
+		imageURL, err = inputImageURL(opts, file)
+	}
+	if err != nil {
+		return jobResult{}, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	sizeValue := resolveSizeFor(info, opts.size, true, file)
+	req, err := buildRequest(opts, info, opts.model, prompt, sizeValue, []string{imageURL})
+	if err != nil {
+		return jobResult{}, err
+	}
+	if jobSeed >= 0 {
+		req.Seed = &jobSeed
+	}
+
+	meta := ImageMetadata{
+		Prompt:         prompt,
+		NegativePrompt: req.NegativePrompt,
+		Model:          resolvedModel,
+		ModelPath:      modelPath,
+		Size:           sizeValue,
+		Images:         absPaths([]string{file}),
+		Format:         opts.format,
+		Strength:       req.Strength,
+	}
+	path, err := runJobRequest(opts, apiKey, info, modelPath, req, &meta, dest)
+	if err != nil {
+		return jobResult{}, err
+	}
+	return jobResult{path: path, caption: filepath.Base(file)}, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"os"
+	"strconv"
+)
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	jpegSOI      = []byte{0xFF, 0xD8}
+)
+
+// embedMetadata writes the generation parameters into the image file
+// itself: iTXt chunks for PNG and an EXIF UserComment for JPEG. The
+// metadata is spliced in without re-encoding, so pixels are untouched.
+// Other formats are left as they are.
+func embedMetadata(path string, meta ImageMetadata) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	switch {
+	case bytes.HasPrefix(data, pngSignature):
+		out, err = embedPNGText(data, pngTextFields(meta))
+	case bytes.HasPrefix(data, jpegSOI):
+		comment, jerr := json.Marshal(meta)
+		if jerr != nil {
+			return jerr
+		}
+		out, err = embedJPEGComment(data, comment)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0644)
+}
+
+// pngTextFields lists the metadata as PNG text keywords and values, in order
+func pngTextFields(meta ImageMetadata) [][2]string {
+	fields := [][2]string{{"prompt", meta.Prompt}}
+	if meta.NegativePrompt != "" {
+		fields = append(fields, [2]string{"negative_prompt", meta.NegativePrompt})
+	}
+	fields = append(fields,
+		[2]string{"seed", strconv.Itoa(meta.Seed)},
+		[2]string{"model", meta.Model},
+		[2]string{"model_path", meta.ModelPath},
+	)
+	if meta.Size != "" {
+		fields = append(fields, [2]string{"size", meta.Size})
+	}
+	if meta.Steps > 0 {
+		fields = append(fields, [2]string{"steps", strconv.Itoa(meta.Steps)})
+	}
+	if meta.Guidance > 0 {
+		fields = append(fields, [2]string{"guidance", strconv.FormatFloat(meta.Guidance, 'f', -1, 64)})
+	}
+	return append(fields, [2]string{"software", "gen " + version})
+}
+
+// embedPNGText inserts an uncompressed iTXt chunk per field right after
+// the IHDR chunk. iTXt is used rather than tEXt so prompts can be UTF-8.
+func embedPNGText(data []byte, fields [][2]string) ([]byte, error) {
+	// The signature is followed by IHDR: length, type, 13 bytes of data, CRC
+	ihdrEnd := len(pngSignature) + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd || string(data[len(pngSignature)+4:len(pngSignature)+8]) != "IHDR" {
+		return nil, errors.New("malformed PNG: missing IHDR chunk")
+	}
+
+	var chunks bytes.Buffer
+	for _, field := range fields {
+		// keyword, null, compression flag and method, empty language tag and
+		// translated keyword (each null-terminated), then the text
+		var body bytes.Buffer
+		body.WriteString(field[0])
+		body.Write([]byte{0, 0, 0, 0, 0})
+		body.WriteString(field[1])
+		writePNGChunk(&chunks, "iTXt", body.Bytes())
+	}
+
+	out := make([]byte, 0, len(data)+chunks.Len())
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunks.Bytes()...)
+	return append(out, data[ihdrEnd:]...), nil
+}
+
+func writePNGChunk(buf *bytes.Buffer, chunkType string, body []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(body)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(body)
+	buf.WriteString(chunkType)
+	buf.Write(body)
+	binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
+
+// embedJPEGComment adds an APP1 EXIF segment holding comment as the
+// UserComment tag. It goes after the JFIF APP0 segment if there is one,
+// since JFIF requires APP0 to come first.
+func embedJPEGComment(data, comment []byte) ([]byte, error) {
+	pos := len(jpegSOI)
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		segmentLen := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == 0xE1 && bytes.HasPrefix(data[pos+4:], []byte("Exif\x00\x00")) {
+			return nil, errors.New("image already has EXIF data")
+		}
+		if marker != 0xE0 {
+			break
+		}
+		pos += 2 + segmentLen
+	}
+	if pos > len(data) {
+		return nil, errors.New("malformed JPEG")
+	}
+
+	// A little-endian TIFF structure: IFD0 holds a pointer to the EXIF IFD,
+	// which holds UserComment. Its value starts with an 8-byte character
+	// code; ASCII is the most widely read.
+	value := append([]byte("ASCII\x00\x00\x00"), comment...)
+	const (
+		ifd0Offset = 8
+		exifOffset = ifd0Offset + 2 + 12 + 4
+		dataOffset = exifOffset + 2 + 12 + 4
+	)
+	var tiff bytes.Buffer
+	le := binary.LittleEndian
+	tiff.WriteString("II")
+	binary.Write(&tiff, le, uint16(42))
+	binary.Write(&tiff, le, uint32(ifd0Offset))
+	writeIFD(&tiff, 0x8769, 4, 1, exifOffset)                  // ExifIFDPointer, LONG
+	writeIFD(&tiff, 0x9286, 7, uint32(len(value)), dataOffset) // UserComment, UNDEFINED
+	tiff.Write(value)
+
+	segmentLen := 2 + len("Exif\x00\x00") + tiff.Len()
+	if segmentLen > 0xFFFF {
+		return nil, errors.New("metadata is too large for a JPEG EXIF segment")
+	}
+	var segment bytes.Buffer
+	segment.Write([]byte{0xFF, 0xE1})
+	binary.Write(&segment, binary.BigEndian, uint16(segmentLen))
+	segment.WriteString("Exif\x00\x00")
+	segment.Write(tiff.Bytes())
+
+	out := make([]byte, 0, len(data)+segment.Len())
+	out = append(out, data[:pos]...)
+	out = append(out, segment.Bytes()...)
+	return append(out, data[pos:]...), nil
+}
+
+// writeIFD writes a single-entry TIFF IFD with no following IFD
+func writeIFD(buf *bytes.Buffer, tag, fieldType uint16, count, value uint32) {
+	le := binary.LittleEndian
+	binary.Write(buf, le, uint16(1))
+	binary.Write(buf, le, tag)
+	binary.Write(buf, le, fieldType)
+	binary.Write(buf, le, count)
+	binary.Write(buf, le, value)
+	binary.Write(buf, le, uint32(0))
+}
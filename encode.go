@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/image/draw"
+)
+
+// parseByteSize parses sizes like "2MB", "500k", or "1048576" into bytes
+func parseByteSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		mult   int64
+	}{
+		{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	} {
+		if strings.HasSuffix(str, unit.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, unit.suffix))
+			multiplier = unit.mult
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid size '%s' (expected e.g. 2MB, 500KB)", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// decodeImageFile decodes the image at path, returning the format name
+func decodeImageFile(path string) (image.Image, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	img, format, err := image.Decode(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, format, nil
+}
+
+// resizeImage scales img by factor, preserving aspect ratio
+func resizeImage(img image.Image, factor float64) image.Image {
+	b := img.Bounds()
+	width := max(1, int(float64(b.Dx())*factor))
+	height := max(1, int(float64(b.Dy())*factor))
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// encodeImage encodes img in the named format. quality applies to JPEG
+// only and pngLevel to PNG only.
+func encodeImage(img image.Image, format string, quality int, pngLevel png.CompressionLevel) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case "png":
+		err = (&png.Encoder{CompressionLevel: pngLevel}).Encode(&buf, img)
+	default:
+		return nil, fmt.Errorf("cannot re-encode %s images", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pngCompressionLevels maps --png-compression names to encoder levels
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"none":    png.NoCompression,
+	"fast":    png.BestSpeed,
+	"default": png.DefaultCompression,
+	"best":    png.BestCompression,
+}
+
+// pngLevel returns the compression for PNGs gen encodes: the
+// --png-compression level, or the best compression when it isn't set
+func (o *genOptions) pngLevel() png.CompressionLevel {
+	if level, ok := pngCompressionLevels[strings.ToLower(o.pngCompression)]; ok {
+		return level
+	}
+	return png.BestCompression
+}
+
+// parseEncodingFlags checks --quality and --png-compression, and warns
+// when neither the output format nor --also can use them
+func parseEncodingFlags(opts *genOptions, cmd *cobra.Command) error {
+	formats := append([]string{opts.format}, opts.alsoFormats...)
+	if cmd.Flags().Changed("quality") {
+		if opts.jpegQuality < 1 || opts.jpegQuality > 100 {
+			return errors.New("--quality must be between 1 and 100")
+		}
+		if !slices.Contains(formats, "jpeg") && !slices.Contains(formats, "webp") {
+			if err := ignoredFlag("--quality only applies to JPEG and WebP output"); err != nil {
+				return err
+			}
+		}
+		if opts.format == "webp" {
+			if err := checkWebPEncoder(); err != nil {
+				return fmt.Errorf("--quality: %w", err)
+			}
+		}
+	}
+	if opts.pngCompression != "" {
+		if _, ok := pngCompressionLevels[strings.ToLower(opts.pngCompression)]; !ok {
+			return fmt.Errorf("invalid --png-compression '%s' (valid: none, fast, default, best)", opts.pngCompression)
+		}
+		if !slices.Contains(formats, "png") {
+			if err := ignoredFlag("--png-compression only applies to PNG output"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// outputQuality is the JPEG and WebP quality for images gen converts
+func outputQuality(opts *genOptions) int {
+	if opts.jpegQuality > 0 {
+		return opts.jpegQuality
+	}
+	return 90
+}
+
+// matchFormat makes a downloaded image match the format its name promises.
+// The file's bytes decide, since models don't always honor the requested
+// format and the Content-Type may not say either. A mismatched image is
+// converted, or renamed to its real extension when gen can't encode the
+// wanted format. It returns the image's path and format afterwards.
+func matchFormat(opts *genOptions, path, want string) (string, string, error) {
+	want, err := normalizeFormat(want)
+	if err != nil {
+		return path, want, nil // Not a format gen writes, e.g. from gen download
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	_, got, err := image.DecodeConfig(file)
+	file.Close()
+	if err != nil || got == want {
+		return path, want, nil
+	}
+
+	img, _, err := decodeImageFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	var data []byte
+	if want == "webp" {
+		data, err = encodeWebP(img, outputQuality(opts))
+	} else {
+		data, err = encodeImage(img, want, outputQuality(opts), opts.pngLevel())
+	}
+	if err == nil {
+		warnf("FAL returned %s instead of %s; converted it to %s", got, want, want)
+		return path, want, os.WriteFile(path, data, 0644)
+	}
+
+	renamed := strings.TrimSuffix(path, filepath.Ext(path)) + "." + got
+	if err := os.Rename(path, renamed); err != nil {
+		return "", "", err
+	}
+	warnf("FAL returned %s instead of %s and it could not be converted (%v); saved it as %s", got, want, err, renamed)
+	return renamed, got, nil
+}
+
+// reencodeOutput re-encodes a downloaded image when --quality or
+// --png-compression asks for something FAL doesn't control. WebP goes
+// through cwebp; other formats are left as they are.
+func reencodeOutput(opts *genOptions, path string) error {
+	if opts.jpegQuality == 0 && opts.pngCompression == "" {
+		return nil
+	}
+	img, imgFormat, err := decodeImageFile(path)
+	if err != nil {
+		return err
+	}
+	lossy := imgFormat == "jpeg" || imgFormat == "webp"
+	if (!lossy || opts.jpegQuality == 0) && (imgFormat != "png" || opts.pngCompression == "") {
+		return nil
+	}
+	var data []byte
+	if imgFormat == "webp" {
+		data, err = encodeWebP(img, opts.jpegQuality)
+	} else {
+		data, err = encodeImage(img, imgFormat, opts.jpegQuality, opts.pngLevel())
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// fitFileSize re-encodes the image at path until it is at most limit bytes,
+// lowering JPEG or WebP quality first and then downscaling. It returns a
+// description of the adjustment, or an error if the target could not be met.
+func fitFileSize(opts *genOptions, path string, limit int64) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() <= limit {
+		return "", nil
+	}
+
+	img, format, err := decodeImageFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	qualities := []int{0}
+	if format == "jpeg" || format == "webp" {
+		qualities = []int{90, 80, 70, 60, 50, 40}
+		// Never go above an explicit --quality
+		if opts.jpegQuality > 0 {
+			qualities = slices.DeleteFunc(qualities, func(q int) bool { return q > opts.jpegQuality })
+			if len(qualities) == 0 || qualities[0] != opts.jpegQuality {
+				qualities = append([]int{opts.jpegQuality}, qualities...)
+			}
+		}
+	}
+
+	var best []byte
+	var bestDesc string
+	for _, scale := range []float64{1, 0.9, 0.8, 0.7, 0.6, 0.5, 0.4, 0.3} {
+		candidate := img
+		if scale < 1 {
+			candidate = resizeImage(img, scale)
+		}
+		for _, quality := range qualities {
+			var data []byte
+			if format == "webp" {
+				data, err = encodeWebP(candidate, quality)
+			} else {
+				data, err = encodeImage(candidate, format, quality, opts.pngLevel())
+			}
+			if err != nil {
+				return "", err
+			}
+			if best == nil || len(data) < len(best) {
+				b := candidate.Bounds()
+				best = data
+				bestDesc = fmt.Sprintf("%dx%d", b.Dx(), b.Dy())
+				if quality > 0 {
+					bestDesc += fmt.Sprintf(", quality %d", quality)
+				}
+			}
+			if int64(len(data)) <= limit {
+				if err := os.WriteFile(path, data, 0644); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("%s -> %s (%s)", formatBytes(info.Size()), formatBytes(int64(len(data))), bestDesc), nil
+			}
+		}
+	}
+
+	// Keep the smallest attempt even though it misses the target
+	if int64(len(best)) < info.Size() {
+		if err := os.WriteFile(path, best, 0644); err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("could not reduce %s below %s (smallest: %s at %s)",
+		formatBytes(info.Size()), formatBytes(limit), formatBytes(int64(len(best))), bestDesc)
+}
+
+// fittedImageDataURI downscales the image at path by factor and returns it
+// as a data URI. JPEGs stay JPEG; everything else is sent as PNG.
+func fittedImageDataURI(path string, factor float64) (string, error) {
+	img, format, err := decodeImageFile(path)
+	if err != nil {
+		return "", err
+	}
+	if format != "jpeg" {
+		format = "png"
+	}
+
+	resized := resizeImage(img, factor)
+	data, err := encodeImage(resized, format, 90, png.BestCompression)
+	if err != nil {
+		return "", err
+	}
+
+	from, to := img.Bounds(), resized.Bounds()
+	infof("Resized %s: %dx%d -> %dx%d to fit the model's megapixel limit\n",
+		filepath.Base(path), from.Dx(), from.Dy(), to.Dx(), to.Dy())
+	return fmt.Sprintf("data:image/%s;base64,%s", format, base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// checkWebPEncoder reports whether WebP images can be written. Go has no
+// WebP encoder, so this needs the cwebp CLI from libwebp.
+func checkWebPEncoder() error {
+	if _, err := exec.LookPath("cwebp"); err != nil {
+		return errors.New("saving WebP requires the 'cwebp' CLI (from libwebp)")
+	}
+	return nil
+}
+
+// encodeWebP encodes img as WebP using cwebp
+func encodeWebP(img image.Image, quality int) ([]byte, error) {
+	if err := checkWebPEncoder(); err != nil {
+		return nil, err
+	}
+	tmpDir, err := os.MkdirTemp("", "gen-cli-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The PNG is only cwebp's input, so favor speed over size
+	data, err := encodeImage(img, "png", 0, png.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	src, dst := filepath.Join(tmpDir, "in.png"), filepath.Join(tmpDir, "out.webp")
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		return nil, err
+	}
+	out, err := exec.Command("cwebp", "-quiet", "-q", strconv.Itoa(quality), src, "-o", dst).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("cwebp failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.ReadFile(dst)
+}
+
+// saveAlsoFormats converts the image at src into each of formats (from
+// --also) and writes the result next to every local file in destinations,
+// with the format's extension. Formats the image is already in are skipped.
+// It returns the paths written.
+func saveAlsoFormats(opts *genOptions, src string, destinations, formats []string) ([]string, error) {
+	var local []string
+	for _, dest := range destinations {
+		if dest != "stdout" && !isRemoteDest(dest) {
+			local = append(local, dest)
+		}
+	}
+	if len(local) == 0 {
+		warnf("--also needs a local output file; skipping it")
+		return nil, nil
+	}
+
+	img, srcFormat, err := decodeImageFile(src)
+	if err != nil {
+		return nil, err
+	}
+	var written []string
+	quality := outputQuality(opts)
+	for _, format := range formats {
+		if format == srcFormat {
+			debugf("Image is already %s; not converting it\n", format)
+			continue
+		}
+		var data []byte
+		if format == "webp" {
+			data, err = encodeWebP(img, quality)
+		} else {
+			data, err = encodeImage(img, format, quality, opts.pngLevel())
+		}
+		if err != nil {
+			return written, fmt.Errorf("--also %s: %w", format, err)
+		}
+		for _, dest := range local {
+			path := strings.TrimSuffix(dest, filepath.Ext(dest)) + "." + format
+			if path == dest {
+				continue // Named for this format already; don't overwrite it
+			}
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				return written, fmt.Errorf("failed to write %s: %w", path, err)
+			}
+			written = append(written, path)
+		}
+	}
+	return written, nil
+}
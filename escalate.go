@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// defaultEscalationLadder orders models from fastest and cheapest to most
+// robust. --retry-different-model walks up it from the failed model.
+var defaultEscalationLadder = []string{"z-turbo", "qwen", "flux2-pro", "nano-banana-pro"}
+
+// escalationCandidates returns the models to try after failedModel, in order.
+// Models that can't handle the current mode are skipped.
+func escalationCandidates(opts *genOptions, failedModel string, isEditMode bool) []string {
+	ladder := opts.escalationLadder
+	if len(ladder) == 0 {
+		ladder = defaultEscalationLadder
+	}
+
+	// Start above the failed model, or at the bottom if it isn't on the ladder
+	start := 0
+	for i, name := range ladder {
+		if resolveModel(name) == failedModel {
+			start = i + 1
+			break
+		}
+	}
+
+	var candidates []string
+	for _, name := range ladder[start:] {
+		resolved := resolveModel(name)
+		info, ok := models[resolved]
+		if !ok || resolved == failedModel || slices.Contains(candidates, resolved) {
+			continue
+		}
+		if isEditMode && !info.canEdit() {
+			continue
+		}
+		candidates = append(candidates, resolved)
+	}
+	return candidates
+}
+
+// canEscalate reports whether another model might succeed where err failed.
+// Ctrl-C, authentication failures, and invalid requests (400 and 422, which
+// aren't retried either) are returned as they are.
+func canEscalate(err error) bool {
+	if errors.Is(err, errInterrupted) || appCtx.Err() != nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusUnprocessableEntity:
+			return false
+		}
+	}
+	return true
+}
+
+// escalate retries a failed generation on progressively more robust models
+// and returns the first successful response along with the model that
+// produced it. It stops at the first error canEscalate rules out.
+func escalate(opts *genOptions, apiKey, failedModel, prompt, requestedSize string, imageURLs []string, firstErr error) (*ImageResponse, string, error) {
+	if !canEscalate(firstErr) {
+		return nil, failedModel, firstErr
+	}
+
+	isEditMode := len(imageURLs) > 0
+	candidates := escalationCandidates(opts, failedModel, isEditMode)
+	if len(candidates) == 0 {
+		return nil, failedModel, firstErr
+	}
+
+	lastErr := firstErr
+	current := failedModel
+	for _, name := range candidates {
+		if appCtx.Err() != nil {
+			return nil, current, errInterrupted
+		}
+		info := models[name]
+		infof("Generation with %s failed: %v\n", current, lastErr)
+		if price := estimatedPrice(name); price > 0 {
+			infof("Escalating to %s (a higher-tier model; ~%s per image)\n", name, formatPrice(price))
+		} else {
+			infof("Escalating to %s (a higher-tier model; this may cost more per image)\n", name)
+		}
+
+		modelPath, err := modelPathFor(opts, info, name, isEditMode)
+		if err != nil {
+			continue
+		}
+		req, err := buildRequest(opts, info, name, prompt, resolveSizeValue(opts, info, requestedSize, isEditMode), imageURLs)
+		if err != nil {
+			infof("Skipping %s: %v\n", name, err)
+			continue
+		}
+		recordRequest(req)
+
+		response, err := callFALAPI(opts.falClient(), apiKey, info, modelPath, req)
+		if err == nil {
+			infof("Escalated: result produced by %s\n", name)
+			return response, name, nil
+		}
+		if !canEscalate(err) {
+			return nil, name, err
+		}
+		current, lastErr = name, err
+	}
+
+	return nil, current, fmt.Errorf("all escalation models failed; last error: %w", lastErr)
+}
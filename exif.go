@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// jpegOrientation returns the EXIF orientation (1-8) of a JPEG, or 1 if it
+// has none. Only the APP segments at the start of the file are read.
+func jpegOrientation(r io.Reader) int {
+	// EXIF lives in an APP1 segment, which is at most 64KB
+	data, err := io.ReadAll(io.LimitReader(r, 128*1024))
+	if err != nil || !bytes.HasPrefix(data, jpegSOI) {
+		return 1
+	}
+
+	pos := len(jpegSOI)
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		segmentLen := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + segmentLen
+		if marker < 0xE0 || marker > 0xEF || end > len(data) {
+			break // Past the APP segments
+		}
+		if body := data[pos+4 : end]; marker == 0xE1 && bytes.HasPrefix(body, []byte("Exif\x00\x00")) {
+			return tiffOrientation(body[len("Exif\x00\x00"):])
+		}
+		pos = end
+	}
+	return 1
+}
+
+// tiffOrientation reads the Orientation tag from IFD0 of a TIFF structure
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := range entries {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		// Orientation is a SHORT, stored in the first two bytes of the value
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if v := int(order.Uint16(tiff[entry+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
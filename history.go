@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyLimit int
+	historyJSON  bool
+
+	redoModel  string
+	redoSize   string
+	redoFormat string
+	redoSeed   int
+)
+
+// HistoryEntry is one line of ~/.gen-cli/history.jsonl, recorded for every
+// successful generation
+type HistoryEntry struct {
+	ImageMetadata
+	Outputs        []string `json:"outputs"`
+	Count          int      `json:"count,omitempty"`           // Images generated; 0 in older entries, which had one
+	ElapsedSeconds float64  `json:"elapsed_seconds,omitempty"` // Time spent on the API call
+}
+
+// images returns how many images the entry generated
+func (e HistoryEntry) images() int {
+	return max(e.Count, 1)
+}
+
+// etaWindow is how many recent runs of a model the time estimate averages
+const etaWindow = 5
+
+// estimateDuration averages the API time of the last few runs of modelPath
+// in the history. It returns 0 when there are none to go on.
+func estimateDuration(modelPath string) (time.Duration, int) {
+	entries, err := readHistory()
+	if err != nil {
+		return 0, 0
+	}
+	var total float64
+	runs := 0
+	for i := len(entries) - 1; i >= 0 && runs < etaWindow; i-- {
+		if entries[i].ModelPath == modelPath && entries[i].ElapsedSeconds > 0 {
+			total += entries[i].ElapsedSeconds
+			runs++
+		}
+	}
+	if runs == 0 {
+		return 0, 0
+	}
+	return time.Duration(total / float64(runs) * float64(time.Second)), runs
+}
+
+func historyPath() string {
+	genDir := getGenCLIDir()
+	if genDir == "" {
+		return ""
+	}
+	return filepath.Join(genDir, "history.jsonl")
+}
+
+// historyMu serializes appends from concurrent batch jobs
+var historyMu sync.Mutex
+
+// appendHistory adds an entry to the history file
+func appendHistory(entry HistoryEntry) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	path := historyPath()
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// readHistory returns all history entries, oldest first. Lines that don't
+// parse are skipped so one bad write can't hide the rest.
+func readHistory() ([]HistoryEntry, error) {
+	path := historyPath()
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func runHistory(cmd *cobra.Command, args []string) {
+	entries, err := readHistory()
+	if err != nil {
+		fatalf("failed to read history: %v", err)
+	}
+	// IDs are 1-based positions in the whole file, for gen redo
+	firstID := 1
+	if historyLimit > 0 && len(entries) > historyLimit {
+		firstID = len(entries) - historyLimit + 1
+		entries = entries[len(entries)-historyLimit:]
+	}
+
+	if historyJSON {
+		for _, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				fatalf("%v", err)
+			}
+			fmt.Println(string(data))
+		}
+		return
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No history yet.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tMODEL\tSEED\tPROMPT\tOUTPUT")
+	for i, entry := range entries {
+		output := ""
+		if len(entry.Outputs) > 0 {
+			output = entry.Outputs[0]
+			if len(entry.Outputs) > 1 {
+				output += fmt.Sprintf(" (+%d)", len(entry.Outputs)-1)
+			}
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\n", firstID+i,
+			entry.CreatedAt.Local().Format("2006-01-02 15:04"), entry.Model, entry.Seed, truncate(entry.Prompt, 50), output)
+	}
+	w.Flush()
+}
+
+// runRedo regenerates a history entry with its original settings, applying
+// any overrides given on the command line
+func runRedo(opts *genOptions, cmd *cobra.Command, args []string) {
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		fatalf("invalid history id '%s' (see 'gen history')", args[0])
+	}
+	entries, err := readHistory()
+	if err != nil {
+		fatalf("failed to read history: %v", err)
+	}
+	if id < 1 || id > len(entries) {
+		fatalf("no history entry %d (see 'gen history')", id)
+	}
+	entry := entries[id-1]
+	recordFlags(cmd)
+
+	for _, img := range append(entry.Images, entry.Mask) {
+		if img == "" || isURL(img) {
+			continue
+		}
+		if _, err := os.Stat(img); err != nil {
+			fatalf("input image %s from the original run is missing", img)
+		}
+	}
+
+	opts.model, opts.size, opts.format, opts.seed = entry.Model, entry.Size, entry.Format, entry.Seed
+	opts.inputImages, opts.maskPath = entry.Images, entry.Mask
+	opts.negativePrompt, opts.steps, opts.guidance = entry.NegativePrompt, entry.Steps, entry.Guidance
+	if entry.Strength != nil {
+		opts.strength, opts.strengthSet = *entry.Strength, true
+	}
+	if entry.Count > 1 {
+		opts.numImages = entry.Count
+	}
+	if cmd.Flags().Changed("model") {
+		opts.model = redoModel
+	}
+	if cmd.Flags().Changed("size") {
+		opts.size = redoSize
+	}
+	if cmd.Flags().Changed("format") {
+		opts.format = redoFormat
+	}
+	if cmd.Flags().Changed("seed") {
+		opts.seed = redoSeed
+	}
+
+	normalized, err := normalizeFormat(opts.format)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	opts.format = normalized
+	if err := prepareOutputDir(opts); err != nil {
+		fatalf("%v", err)
+	}
+
+	if _, err := generate(opts, getAPIKey(), entry.Prompt); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// truncate shortens s to at most n runes, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
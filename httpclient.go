@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyURL is the parsed --proxy; nil means HTTP_PROXY, HTTPS_PROXY, and
+// NO_PROXY from the environment decide
+var proxyURL *url.URL
+
+// httpTransport is shared by every request gen makes, so API calls,
+// polling, and downloads reuse connections and go through the same proxy
+var httpTransport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = func(req *http.Request) (*url.URL, error) {
+		if proxyURL != nil {
+			return proxyURL, nil
+		}
+		return http.ProxyFromEnvironment(req)
+	}
+	return t
+}()
+
+// newHTTPClient returns a client on the shared transport; a zero timeout
+// means none
+func newHTTPClient(timeout time.Duration) *http.Client {
+	var transport http.RoundTripper = httpTransport
+	if debugHTTP {
+		transport = debugTransport{base: httpTransport}
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// httpDoer is the part of *http.Client that FAL API calls need
+type httpDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// setProxy validates and applies --proxy
+func setProxy(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid --proxy '%s' (expected a URL like http://proxy:8080)", raw)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("unsupported --proxy scheme '%s' (use http, https, or socks5)", u.Scheme)
+	}
+	proxyURL = u
+	return nil
+}
+
+// debugHTTP dumps every HTTP exchange to stderr (--debug)
+var debugHTTP bool
+
+var debugMu sync.Mutex
+
+// debugTransport logs requests and responses around another transport.
+// The Authorization header is always redacted and inline images elided.
+type debugTransport struct {
+	base http.RoundTripper
+}
+
+func (t debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			reqBody, _ = io.ReadAll(body)
+			body.Close()
+		}
+	}
+	var dump bytes.Buffer
+	fmt.Fprintf(&dump, "--> %s %s\n", req.Method, req.URL)
+	writeDebugHeaders(&dump, req.Header)
+	writeDebugBody(&dump, req.Header.Get("Content-Type"), reqBody)
+	printDebug(dump.Bytes())
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	dump.Reset()
+	if err != nil {
+		fmt.Fprintf(&dump, "<-- %s %s failed after %.2fs: %v\n", req.Method, req.URL, time.Since(start).Seconds(), err)
+		printDebug(dump.Bytes())
+		return resp, err
+	}
+
+	fmt.Fprintf(&dump, "<-- %s (%.2fs) %s\n", resp.Status, time.Since(start).Seconds(), req.URL)
+	writeDebugHeaders(&dump, resp.Header)
+	contentType := resp.Header.Get("Content-Type")
+	if isTextContentType(contentType) {
+		// Read the body for the dump and hand the caller a fresh copy
+		body, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		if readErr != nil {
+			return resp, readErr
+		}
+		writeDebugBody(&dump, contentType, body)
+	} else if resp.ContentLength >= 0 {
+		fmt.Fprintf(&dump, "<%d bytes of %s>\n\n", resp.ContentLength, contentType)
+	}
+	printDebug(dump.Bytes())
+	return resp, nil
+}
+
+func writeDebugHeaders(w io.Writer, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			if strings.EqualFold(name, "Authorization") {
+				value = "[REDACTED]"
+			}
+			fmt.Fprintf(w, "%s: %s\n", name, value)
+		}
+	}
+}
+
+func writeDebugBody(w io.Writer, contentType string, body []byte) {
+	if len(body) == 0 {
+		fmt.Fprintln(w)
+		return
+	}
+	if !isTextContentType(contentType) {
+		fmt.Fprintf(w, "\n<%d bytes of %s>\n\n", len(body), contentType)
+		return
+	}
+	body = dataURIPattern.ReplaceAll(body, []byte(`"data:$1;base64,<elided>"`))
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, body, "", "  ") == nil {
+		body = pretty.Bytes()
+	}
+	fmt.Fprintf(w, "\n%s\n\n", bytes.TrimRight(body, "\n"))
+}
+
+// isTextContentType reports whether a body is JSON or text, and so worth
+// printing
+func isTextContentType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json")
+}
+
+func printDebug(dump []byte) {
+	debugMu.Lock()
+	defer debugMu.Unlock()
+	os.Stderr.Write(dump)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// appCtx is cancelled by Ctrl-C or SIGTERM. API requests, downloads, and
+// the waits between polls and retries all stop when it is.
+var appCtx = context.Background()
+
+// errInterrupted is returned by work cut short by Ctrl-C
+var errInterrupted = errors.New("interrupted")
+
+// handleInterrupts makes the first Ctrl-C or SIGTERM cancel appCtx so gen
+// can stop cleanly; after that, signals get their default behavior again,
+// so a second Ctrl-C exits at once
+func handleInterrupts() (stop func()) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	appCtx = ctx
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return stop
+}
+
+// sleepCtx waits for d, returning errInterrupted early on Ctrl-C
+func sleepCtx(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-appCtx.Done():
+		return errInterrupted
+	}
+}
+
+// httpGet fetches url with no timeout, stopping on Ctrl-C
+func httpGet(url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(appCtx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := newHTTPClient(0).Do(req)
+	if err != nil && appCtx.Err() != nil {
+		return nil, errInterrupted
+	}
+	return resp, err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// jsonResult is the --json summary of a run
+type jsonResult struct {
+	Model          string      `json:"model"`
+	Seed           int         `json:"seed"`
+	ElapsedSeconds float64     `json:"elapsed_seconds"`
+	Images         []jsonImage `json:"images"`
+}
+
+type jsonImage struct {
+	Paths  []string `json:"paths"`
+	URL    string   `json:"url"`
+	Width  int      `json:"width"`
+	Height int      `json:"height"`
+	Seed   int      `json:"seed"`
+	Model  string   `json:"model"`
+	Size   string   `json:"size,omitempty"`
+}
+
+// printJSONResult writes the combined results of a run as one JSON object.
+// Each image carries its own seed and model, which can differ from the
+// top-level ones when sizes run separately or a fallback model was used.
+func printJSONResult(results []*GenerationResult) {
+	out := jsonResult{Model: results[0].Model, Seed: results[0].Seed, Images: []jsonImage{}}
+	for _, r := range results {
+		out.ElapsedSeconds += r.Elapsed.Seconds()
+		for _, img := range r.Images {
+			out.Images = append(out.Images, jsonImage{
+				Paths:  img.Destinations,
+				URL:    img.URL,
+				Width:  img.Width,
+				Height: img.Height,
+				Seed:   r.Seed,
+				Model:  r.Model,
+				Size:   r.Size,
+			})
+		}
+	}
+	printJSON(out)
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+)
+
+// logLevel controls how much status output is printed
+type logLevel int
+
+const (
+	levelQuiet   logLevel = iota // Saved paths, warnings, and errors only
+	levelNormal                  // Status messages
+	levelVerbose                 // Plus API requests and timings
+)
+
+var currentLogLevel = levelNormal
+
+// infof prints a status message unless --quiet is set
+func infof(format string, args ...any) {
+	if currentLogLevel >= levelNormal {
+		fmt.Fprintf(msgOut, format, args...)
+	}
+}
+
+// debugf prints detail that's only wanted with --verbose
+func debugf(format string, args ...any) {
+	if currentLogLevel >= levelVerbose {
+		fmt.Fprintf(msgOut, format, args...)
+	}
+}
+
+// warnf prints a warning to stderr at every level
+func warnf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", args...)
+}
+
+// logSaved reports a saved output. With --quiet the bare path is printed to
+// stdout for scripts, unless stdout carries image data or JSON.
+func logSaved(opts *genOptions, dest string) {
+	if currentLogLevel > levelQuiet {
+		infof("Image saved to: %s\n", dest)
+		return
+	}
+	if !jsonOutput && !slices.Contains(opts.outputs, stdoutDest) {
+		fmt.Println(dest)
+	}
+}
@@ -1,41 +1,286 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 	"io"
+	"math"
+	"math/rand/v2"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"text/tabwriter"
 	"time"
+	"unicode/utf8"
 
 	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
 	_ "golang.org/x/image/webp"
+	"golang.org/x/term"
 )
 
-const falBaseURL = "https://fal.run"
+// falBaseURL takes direct, synchronous calls. --base-url or FAL_BASE_URL
+// replaces it, e.g. for a gateway or a mock server.
+var falBaseURL = "https://fal.run"
 
-// Models maps short names to their generation and edit paths
-var models = map[string]struct {
+// falQueueBaseURL accepts requests asynchronously; results are polled for.
+// --queue-url or FAL_QUEUE_URL replaces it. It's "" when only the base URL
+// was overridden, so every model is then called directly.
+var falQueueBaseURL = "https://queue.fal.run"
+
+// Set by --base-url and --queue-url
+var baseURLFlag, queueURLFlag string
+
+// setBaseURLs applies --base-url and --queue-url, or else FAL_BASE_URL and
+// FAL_QUEUE_URL
+func setBaseURLs() error {
+	base, queue := baseURLFlag, queueURLFlag
+	if base == "" {
+		base = os.Getenv("FAL_BASE_URL")
+	}
+	if queue == "" {
+		queue = os.Getenv("FAL_QUEUE_URL")
+	}
+	for _, u := range []struct{ name, value string }{{"base URL", base}, {"queue URL", queue}} {
+		if u.value == "" {
+			continue
+		}
+		parsed, err := url.Parse(u.value)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid %s '%s' (expected a URL like https://gateway.example.com)", u.name, u.value)
+		}
+	}
+	if base != "" {
+		falBaseURL = strings.TrimSuffix(base, "/")
+		// A queue on the default host wouldn't match a custom base URL
+		falQueueBaseURL = ""
+	}
+	if queue != "" {
+		falQueueBaseURL = strings.TrimSuffix(queue, "/")
+	}
+	return nil
+}
+
+// defaultTimeout applies to API calls for models without a DefaultTimeout
+const defaultTimeout = 5 * time.Minute
+
+// defaultSize applies to generations for models without a DefaultSize
+const defaultSize = "4:3"
+
+// ModelInfo describes a FAL model and how to call it
+type ModelInfo struct {
 	GenPath             string
 	EditPath            string
+	InpaintPath         string // Endpoint for --mask edits; "" if inpainting isn't supported
 	SupportsAutoImgSize bool   // Whether the model supports "auto" image_size
 	SizeParamName       string // "image_size" or "aspect_ratio"
-}{
-	"z-turbo":         {"fal-ai/z-image/turbo", "", false, "image_size"},
-	"qwen":            {"fal-ai/qwen-image", "fal-ai/qwen-image-edit-plus", false, "image_size"},
-	"flux2-pro":       {"fal-ai/flux-2-pro", "fal-ai/flux-2-pro/edit", true, "image_size"},
-	"flux2-flex":      {"fal-ai/flux-2-flex", "fal-ai/flux-2-flex/edit", true, "image_size"},
-	"nano-banana":     {"fal-ai/nano-banana", "fal-ai/nano-banana/edit", true, "aspect_ratio"},
-	"nano-banana-pro": {"fal-ai/nano-banana-pro", "fal-ai/nano-banana-pro/edit", true, "aspect_ratio"},
+	ExpandPromptParam   string // "enable_prompt_expansion", "expand_prompt", or "enhance_prompt"; "" if the model can't
+
+	// Safety control: models with MaxSafetyTolerance > 0 take a graduated
+	// safety_tolerance from 1 (strictest) up to that value; the rest only
+	// have the boolean enable_safety_checker
+	MaxSafetyTolerance    int
+	SupportsSafetyChecker bool // Accepts enable_safety_checker (--safety / --no-safety)
+
+	SupportsImageWeights   bool // Accepts per-image image_weights for multi-reference edits
+	UsesImageRefs          bool // Prompts refer to input images as @image1, @image2, ...
+	SupportsHexColors      bool // Understands #RRGGBB color codes in prompts
+	SupportsNegativePrompt bool // Accepts negative_prompt
+	SupportsSteps          bool // Accepts num_inference_steps and guidance_scale
+	SupportsStrength       bool // Accepts strength for edits
+	SupportsImg2Img        bool // Without an EditPath, takes one -i image as image_url on GenPath
+
+	PricePerImage float64 // Approximate USD, for cost estimates; 0 if unknown
+
+	// Edit input limits; 0 means no limit is enforced
+	MaxImages     int
+	MaxMegapixels float64 // Summed across all input images
+
+	DefaultTimeout time.Duration // Used when --timeout isn't given; 0 means defaultTimeout
+	DefaultSize    string        // Used when generating without --size; "" means defaultSize
+
+	// Endpoint quirks; the zero values mean falBaseURL + "/" + path for direct
+	// calls and falQueueBaseURL + "/" + path for queued ones
+	BaseURL        string // Overrides falBaseURL for this model
+	QueueBaseURL   string // Overrides falQueueBaseURL; required for queueing when BaseURL is set
+	EndpointSuffix string // Appended to the model path, e.g. "/generate"
+	SyncOnly       bool   // The endpoint doesn't support the queue API
+
+	Custom bool // Defined in ~/.gen-cli/models.yaml
+}
+
+// falClient sends requests to the FAL API. newFALClient sets it up from the
+// flags; tests build one aimed at an httptest server.
+type falClient struct {
+	http         func(timeout time.Duration) httpDoer // Client for one request; a zero timeout means none
+	baseURL      string                               // Takes direct calls
+	queueBaseURL string                               // Takes queued calls; "" calls every model directly
+	timeout      time.Duration                        // Bounds a whole request, queueing included; 0 for none
+	sync         bool                                 // Skip the queue even where there is one
+	maxRetries   int
+	retryMaxWait time.Duration
+}
+
+// newFALClient returns a client for the configured base URLs, with the
+// default timeout and no retries
+func newFALClient() *falClient {
+	return &falClient{
+		http:         func(timeout time.Duration) httpDoer { return newHTTPClient(timeout) },
+		baseURL:      falBaseURL,
+		queueBaseURL: falQueueBaseURL,
+		timeout:      defaultTimeout,
+	}
+}
+
+// endpointURL returns the full URL to POST to for the given model path
+func (c *falClient) endpointURL(m ModelInfo, modelPath string) string {
+	baseURL := c.baseURL
+	if m.BaseURL != "" {
+		baseURL = strings.TrimSuffix(m.BaseURL, "/")
+	}
+	return fmt.Sprintf("%s/%s%s", baseURL, modelPath, m.EndpointSuffix)
+}
+
+// queueURL returns the queue submission URL for the given model path, or ""
+// if the model must be called directly
+func (c *falClient) queueURL(m ModelInfo, modelPath string) string {
+	if m.SyncOnly || (m.BaseURL != "" && m.QueueBaseURL == "") {
+		return ""
+	}
+	baseURL := c.queueBaseURL
+	if m.QueueBaseURL != "" {
+		baseURL = strings.TrimSuffix(m.QueueBaseURL, "/")
+	}
+	if baseURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s%s", baseURL, modelPath, m.EndpointSuffix)
+}
+
+// defaultSize is the size generations use when none is given
+func (m ModelInfo) defaultSize() string {
+	if m.DefaultSize != "" {
+		return m.DefaultSize
+	}
+	return defaultSize
+}
+
+// canEdit reports whether the model takes -i images, through an edit
+// endpoint or as img2img on its gen endpoint
+func (m ModelInfo) canEdit() bool {
+	return m.EditPath != "" || m.SupportsImg2Img
+}
+
+// img2img reports whether -i images go to the gen endpoint as a single
+// conditioning image_url, with --strength, rather than to an edit endpoint
+func (m ModelInfo) img2img() bool {
+	return m.EditPath == "" && m.SupportsImg2Img
+}
+
+// Models maps short names to their generation and edit paths
+var models = map[string]ModelInfo{
+	"z-turbo": {
+		GenPath:               "fal-ai/z-image/turbo",
+		SizeParamName:         "image_size",
+		ExpandPromptParam:     "enable_prompt_expansion",
+		SupportsSafetyChecker: true,
+		PricePerImage:         0.005,
+		DefaultTimeout:        1 * time.Minute,
+	},
+	"qwen": {
+		GenPath:                "fal-ai/qwen-image",
+		EditPath:               "fal-ai/qwen-image-edit-plus",
+		InpaintPath:            "fal-ai/qwen-image-edit/inpaint",
+		SizeParamName:          "image_size",
+		SupportsSafetyChecker:  true,
+		SupportsNegativePrompt: true,
+		PricePerImage:          0.02,
+		DefaultTimeout:         3 * time.Minute,
+	},
+	"flux2-pro": {
+		GenPath:               "fal-ai/flux-2-pro",
+		EditPath:              "fal-ai/flux-2-pro/edit",
+		SupportsAutoImgSize:   true,
+		SizeParamName:         "image_size",
+		MaxSafetyTolerance:    5,
+		SupportsSafetyChecker: true,
+		SupportsImageWeights:  true,
+		UsesImageRefs:         true,
+		MaxImages:             9,
+		MaxMegapixels:         9,
+		PricePerImage:         0.03,
+		DefaultTimeout:        5 * time.Minute,
+	},
+	"flux2-flex": {
+		GenPath:               "fal-ai/flux-2-flex",
+		EditPath:              "fal-ai/flux-2-flex/edit",
+		SupportsAutoImgSize:   true,
+		SizeParamName:         "image_size",
+		ExpandPromptParam:     "enable_prompt_expansion",
+		MaxSafetyTolerance:    5,
+		SupportsSafetyChecker: true,
+		SupportsImageWeights:  true,
+		UsesImageRefs:         true,
+		SupportsSteps:         true,
+		SupportsHexColors:     true,
+		MaxImages:             10,
+		MaxMegapixels:         14,
+		PricePerImage:         0.06,
+		DefaultTimeout:        10 * time.Minute,
+	},
+	"nano-banana": {
+		GenPath:             "fal-ai/nano-banana",
+		EditPath:            "fal-ai/nano-banana/edit",
+		SupportsAutoImgSize: true,
+		SizeParamName:       "aspect_ratio",
+		PricePerImage:       0.039,
+		DefaultTimeout:      3 * time.Minute,
+	},
+	"nano-banana-pro": {
+		GenPath:             "fal-ai/nano-banana-pro",
+		EditPath:            "fal-ai/nano-banana-pro/edit",
+		SupportsAutoImgSize: true,
+		SizeParamName:       "aspect_ratio",
+		MaxImages:           14,
+		PricePerImage:       0.15,
+		DefaultTimeout:      5 * time.Minute,
+	},
+}
+
+// outputFormats are the values accepted by --format
+var outputFormats = []string{"png", "jpeg", "webp"}
+
+// normalizeFormat lowercases an output format, maps jpg to jpeg, and
+// rejects anything not in outputFormats
+func normalizeFormat(f string) (string, error) {
+	f = strings.ToLower(f)
+	if f == "jpg" {
+		f = "jpeg"
+	}
+	if !slices.Contains(outputFormats, f) {
+		return "", fmt.Errorf("unsupported format '%s' (valid: %s)", f, strings.Join(outputFormats, ", "))
+	}
+	return f, nil
 }
 
 // Model aliases
@@ -50,14 +295,32 @@ type ImageSize struct {
 
 type ImageRequest struct {
 	Prompt              string      `json:"prompt"`
+	NegativePrompt      string      `json:"negative_prompt,omitempty"`
 	ImageSize           interface{} `json:"image_size,omitempty"`   // string or ImageSize struct
 	AspectRatio         string      `json:"aspect_ratio,omitempty"` // for nano-banana models
 	OutputFormat        string      `json:"output_format,omitempty"`
 	ImageURLs           []string    `json:"image_urls,omitempty"`
+	ImageURL            string      `json:"image_url,omitempty"` // Inpainting takes a single image
+	MaskURL             string      `json:"mask_url,omitempty"`  // White areas are repainted
 	Seed                *int        `json:"seed,omitempty"`
-	EnableSafetyChecker bool        `json:"enable_safety_checker"`
+	NumImages           int         `json:"num_images,omitempty"`
+	EnableSafetyChecker *bool       `json:"enable_safety_checker,omitempty"` // Only sent for --safety / --no-safety
+	SafetyTolerance     string      `json:"safety_tolerance,omitempty"`      // "1" (strict) to MaxSafetyTolerance
+	ImageWeights        []float64   `json:"image_weights,omitempty"`         // aligned with ImageURLs
+	NumInferenceSteps   int         `json:"num_inference_steps,omitempty"`
+	GuidanceScale       float64     `json:"guidance_scale,omitempty"`
+	Strength            *float64    `json:"strength,omitempty"`  // Edits only; 0 keeps the input, 1 ignores it
+	SyncMode            bool        `json:"sync_mode,omitempty"` // Return images as data URIs instead of URLs
+
+	// --enhance, under whichever name the model's ExpandPromptParam gives
+	EnablePromptExpansion bool `json:"enable_prompt_expansion,omitempty"`
+	ExpandPrompt          bool `json:"expand_prompt,omitempty"`
+	EnhancePrompt         bool `json:"enhance_prompt,omitempty"`
 }
 
+// expandPromptParams are the values ExpandPromptParam may take
+var expandPromptParams = []string{"enable_prompt_expansion", "expand_prompt", "enhance_prompt"}
+
 type ImageOutput struct {
 	URL         string `json:"url"`
 	Width       int    `json:"width"`
@@ -66,20 +329,149 @@ type ImageOutput struct {
 }
 
 type ImageResponse struct {
-	Images []ImageOutput `json:"images"`
-	Seed   int           `json:"seed"`
+	Images []ImageOutput   `json:"images"`
+	Seed   int             `json:"seed"`
+	Raw    json.RawMessage `json:"-"` // The whole response body, for --raw
+
+	// Prompt is the prompt the model used, which models that expand
+	// prompts return rewritten
+	Prompt string `json:"prompt,omitempty"`
+
+	// HasNSFWConcepts is aligned with Images; true marks an image the
+	// safety checker flagged, which models black out or blur
+	HasNSFWConcepts []bool `json:"has_nsfw_concepts,omitempty"`
 }
 
+// warnFlagged warns about images the safety checker flagged, so a blank
+// result doesn't go unexplained, and points at the setting that relaxes it
+func warnFlagged(opts *genOptions, info ModelInfo, resp *ImageResponse) {
+	flagged := 0
+	for _, f := range resp.HasNSFWConcepts {
+		if f {
+			flagged++
+		}
+	}
+	if flagged == 0 {
+		return
+	}
+	what := "the image was"
+	if len(resp.Images) > 1 {
+		what = fmt.Sprintf("%d of %d images were", flagged, len(resp.Images))
+		if flagged == 1 {
+			what = fmt.Sprintf("1 of %d images was", len(resp.Images))
+		}
+	}
+	hint := ""
+	switch {
+	case info.MaxSafetyTolerance > 0 && opts.safetyTol < info.MaxSafetyTolerance:
+		hint = fmt.Sprintf("; a higher --safety-tolerance (up to %d) is more permissive", info.MaxSafetyTolerance)
+	case info.SupportsSafetyChecker && !opts.safetyOff:
+		hint = "; --no-safety turns the checker off"
+	}
+	warnf("%s flagged by the safety checker and may be blacked out or blurred%s", what, hint)
+}
+
+// genOptions holds the generation flags. main binds them and passes them
+// down from runGenerate and the other generating commands.
+type genOptions struct {
+	model          string
+	negativePrompt string
+	size           string
+	aspect         string
+	format         string
+	outputs        []string
+	outputDir      string
+	seed           int
+	numImages      int
+	inputImages    []string
+	refImages      []string
+	maskPath       string
+	preview        bool
+	openResult     bool
+	toClipboard    bool
+	urlOnly        bool
+	noEmbed        bool
+	nameTemplate   string
+	subdirByModel  bool
+	translate      bool
+	maxFileSize    string
+	jpegQuality    int
+	pngCompression string
+	safetyTol      int
+	safetyOn       bool
+	enhance        bool
+	safetyOff      bool
+	imageWeights   []float64
+	steps          int
+	guidance       float64
+	strength       float64
+
+	targetSimilarity   string
+	minSimilarity      float64
+	maxSimilarity      float64
+	similarityAttempts int
+
+	rawOutput     bool
+	writeMetadata bool
+	dryRun        bool
+	inlineURLs    bool
+	inlineResult  bool
+	thenPrompt    string
+	thenModel     string
+	outputSuffix  string // Appended to every output name, e.g. "_edit" for --then
+	alsoFormats   []string
+	fitInputs     bool
+	saveSeed      bool
+	randomSeed    bool
+	interactive   bool
+	confirm       bool
+	promptFile    string
+	watch         bool
+
+	batchConcurrency int
+	contactSheet     string   // --contact-sheet path
+	matrixFlags      []string // Raw --matrix key=v1,v2 values
+	seedSweep        int      // Number of generations --seed-sweep runs
+
+	useSync      bool
+	maxRetries   int
+	retryMaxWait time.Duration
+
+	retryDifferentModel bool
+	escalationLadder    []string
+	timeout             time.Duration
+
+	requestTimeout time.Duration // Resolved from --timeout or the model default
+	timeoutSet     bool          // --timeout was given explicitly
+	strengthSet    bool          // --strength was given explicitly
+	maskURL        string        // Data URI of --mask, resolved in generate
+
+	maxFileSizeBytes int64 // Parsed from maxFileSize
+}
+
+// Settings for the whole process rather than one generation, read by
+// logging, error reporting, and prompts
 var (
-	model       string
-	size        string
-	format      string
-	output      string
-	seed        int
-	inputImages []string
+	saveOnError string
+	jsonOutput  bool
+	assumeYes   bool
+	strict      bool
+	noProgress  bool
+	proxy       string
+	quiet       bool
+	verbose     bool
+
+	modelsJSON  bool
+	modelsPlain bool
+	modelsPaths bool
 )
 
+// msgOut receives progress and status messages. It moves to stderr when
+// image data or --json output is written to stdout so the two don't mix.
+var msgOut io.Writer = os.Stdout
+
 func main() {
+	opts := &genOptions{requestTimeout: defaultTimeout}
 	rootCmd := &cobra.Command{
 		Use:   "gen [prompt]",
 		Short: "Image Generator CLI",
@@ -102,59 +494,348 @@ For flux2-flex, you can also use HEX color codes:
 Limits: flux2-pro supports up to 9 images (9MP total),
         flux2-flex supports up to 10 images (14MP total),
         nano-banana-pro supports up to 14 images.`,
-		Args:                  cobra.MaximumNArgs(1),
-		DisableAutoGenTag:     true,
-		CompletionOptions:     cobra.CompletionOptions{DisableDefaultCmd: true},
+		Args:              cobra.MaximumNArgs(1),
+		DisableAutoGenTag: true,
 		Example: `  gen "a cat in space"
   gen "cyberpunk city" -m flux2-pro -s 16:9
   gen "add sunglasses" -i photo.png
-  gen "@image1 in the style of @image2" -i content.png -i style.png -m flux2-pro`,
-		Run: runGenerate,
+  gen "@image1 in the style of @image2" -i content.png -i style.png -m flux2-pro
+  echo "a dragon" | gen -m flux2-pro`,
+		Run: func(cmd *cobra.Command, args []string) { runGenerate(opts, cmd, args) },
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			if slices.Contains(opts.outputs, stdoutDest) || jsonOutput || opts.rawOutput {
+				msgOut = os.Stderr
+			}
+			if quiet && verbose {
+				fatalf("--quiet and --verbose can't be used together")
+			}
+			if quiet {
+				currentLogLevel = levelQuiet
+			} else if verbose {
+				currentLogLevel = levelVerbose
+			}
+			if noProgress || quiet {
+				progressEnabled = false
+			}
+			if proxy != "" {
+				if err := setProxy(proxy); err != nil {
+					fatalf("%v", err)
+				}
+			}
+			if err := setBaseURLs(); err != nil {
+				fatalf("%v", err)
+			}
+
+			cfg, err := loadConfig()
+			if err != nil {
+				fatalf("%v", err)
+			}
+			config = cfg
+
+			if err := loadCustomModels(); err != nil {
+				fatalf("%v", err)
+			}
+			startUpdateCheck()
+		},
 	}
 
-	rootCmd.Flags().StringVarP(&model, "model", "m", "z-turbo", "Model to use")
-	rootCmd.Flags().StringArrayVarP(&inputImages, "image", "i", nil, "Input image(s) for editing")
-	rootCmd.Flags().StringVarP(&size, "size", "s", "", "Aspect ratio: 16:9, 4:3, 1:1, 3:4, 9:16 (default: 4:3 for gen, auto for edit)")
-	rootCmd.Flags().StringVarP(&format, "format", "f", "png", "Output format (png, jpeg)")
-	rootCmd.Flags().StringVarP(&output, "output", "o", "", "Output file path")
-	rootCmd.Flags().IntVar(&seed, "seed", -1, "Seed for reproducibility")
+	rootCmd.Flags().StringVarP(&opts.model, "model", "m", "z-turbo", "Model to use")
+	rootCmd.Flags().StringVar(&opts.promptFile, "prompt-file", "", "Read the prompt from a file instead of the command line")
+	rootCmd.Flags().BoolVar(&opts.watch, "watch", false, "Regenerate each time the --prompt-file is saved, to the same output path")
+	rootCmd.Flags().StringVarP(&opts.negativePrompt, "negative", "N", "", "What to keep out of the image (models that support it, e.g. qwen)")
+	rootCmd.Flags().StringVar(&opts.thenPrompt, "then", "", "After generating, edit the result with this prompt")
+	rootCmd.Flags().StringVar(&opts.thenModel, "then-model", "", "Model for the --then edit (default: the same model)")
+	rootCmd.Flags().StringVar(&opts.maskPath, "mask", "", "Mask image for inpainting: white areas of the single -i image are repainted")
+	rootCmd.Flags().StringArrayVarP(&opts.inputImages, "image", "i", nil, "Input image(s) for editing: local files or http(s) URLs; a glob or directory edits each file separately")
+	rootCmd.Flags().StringArrayVar(&opts.refImages, "ref", nil, "Reference image(s) for an -i edit, sent after the -i images (repeatable)")
+	rootCmd.Flags().IntVar(&opts.seedSweep, "seed-sweep", 0, "Generate N variations with consecutive seeds from --seed (or a random start), each saved with its seed in the file name")
+	rootCmd.Flags().StringArrayVar(&opts.matrixFlags, "matrix", nil, "Run every combination of settings, e.g. --matrix model=flux2-pro,nano-banana (repeatable; keys: model, size, seed, steps, guidance)")
+	rootCmd.Flags().StringVar(&opts.contactSheet, "contact-sheet", "", "Also tile every image from the run into one captioned grid PNG at this path")
+	rootCmd.Flags().IntVar(&opts.batchConcurrency, "concurrency", 1, "Number of files (glob or directory -i) or --matrix combinations to run in parallel")
+	rootCmd.Flags().BoolVar(&opts.fitInputs, "fit", false, "Downscale input images that exceed the model's megapixel limit instead of failing")
+	rootCmd.Flags().BoolVar(&opts.inlineResult, "inline", false, "Have FAL return the image data in its response instead of a URL to download")
+	rootCmd.Flags().BoolVar(&opts.inlineURLs, "inline-urls", false, "Download http(s) -i images and send them inline instead of passing the URL to FAL")
+	rootCmd.Flags().Float64SliceVar(&opts.imageWeights, "image-weight", nil, "Relative influence (0-1) of each input image, in -i then --ref order (repeatable)")
+	rootCmd.Flags().StringVarP(&opts.size, "size", "s", "", "Exact size as WIDTHxHEIGHT, or an aspect ratio as with --aspect; a comma list generates each (default: the model's default for gen, auto for edit)")
+	rootCmd.Flags().StringVar(&opts.aspect, "aspect", "", "Aspect ratio such as 16:9, 1:1, or auto (see gen sizes); takes precedence over --size")
+	rootCmd.Flags().StringVarP(&opts.format, "format", "f", "png", "Output format (png, jpeg, webp)")
+	rootCmd.Flags().StringSliceVar(&opts.alsoFormats, "also", nil, "Also save a copy in this format, converted locally (can specify multiple)")
+	rootCmd.Flags().StringArrayVarP(&opts.outputs, "output", "o", nil, "Output destination: file, directory, - for stdout, or s3://, gs:// URL (repeatable)")
+	rootCmd.Flags().StringVar(&opts.outputDir, "output-dir", "", "Directory for auto-named images, created if needed (default: ~/.gen-cli/output)")
+	rootCmd.Flags().IntVar(&opts.seed, "seed", -1, "Seed for reproducibility (default: random, printed after each run)")
+	rootCmd.Flags().BoolVar(&opts.randomSeed, "random-seed", false, "Let the server choose the seed instead of picking one locally")
+	rootCmd.Flags().BoolVar(&opts.saveSeed, "save-seed", false, "Write the seed to a <name>.seed file next to each image")
+	rootCmd.Flags().IntVarP(&opts.numImages, "num", "n", 1, "Number of images to generate per call")
+	rootCmd.Flags().BoolVar(&opts.translate, "translate", false, "Translate non-English prompts to English before generating")
+	rootCmd.Flags().IntVar(&opts.steps, "steps", 0, "Number of inference steps (flux2-flex)")
+	rootCmd.Flags().Float64Var(&opts.guidance, "guidance", 0, "Guidance scale: how closely to follow the prompt (flux2-flex)")
+	rootCmd.Flags().Float64Var(&opts.strength, "strength", 0, "How far an edit may depart from the input image, 0-1 (models that support it)")
+	rootCmd.Flags().BoolVar(&opts.enhance, "enhance", false, "Let the model rewrite the prompt into a richer one, and print what it used (models that support it)")
+	rootCmd.Flags().BoolVar(&opts.safetyOn, "safety", false, "Turn the model's safety checker on (default: the model's own default)")
+	rootCmd.Flags().BoolVar(&opts.safetyOff, "no-safety", false, "Turn the model's safety checker off")
+	rootCmd.Flags().IntVar(&opts.safetyTol, "safety-tolerance", 0, "Graduated safety tolerance, 1 (strictest) and up, for models that support it")
+	rootCmd.Flags().IntVar(&opts.jpegQuality, "quality", 0, "Re-encode JPEG and WebP output at this quality, 1-100")
+	rootCmd.Flags().StringVar(&opts.pngCompression, "png-compression", "", "Re-encode PNG output with this compression: none, fast, default, or best")
+	rootCmd.Flags().StringVar(&opts.maxFileSize, "max-file-size", "", "Re-encode the result to fit a size budget (e.g. 2MB, 500KB)")
+	rootCmd.Flags().StringVar(&opts.targetSimilarity, "target-similarity", "", "Reference image; regenerate with new seeds until the result's similarity is in range")
+	rootCmd.Flags().Float64Var(&opts.minSimilarity, "min-similarity", 0, "Minimum similarity (0-1) to --target-similarity")
+	rootCmd.Flags().Float64Var(&opts.maxSimilarity, "max-similarity", 1, "Maximum similarity (0-1) to --target-similarity")
+	rootCmd.Flags().IntVar(&opts.similarityAttempts, "similarity-attempts", 5, "Maximum generations when using --target-similarity")
+	rootCmd.Flags().BoolVar(&opts.useSync, "sync", false, "Call the model directly instead of through the FAL queue")
+	rootCmd.Flags().IntVar(&opts.maxRetries, "retries", 3, "Retries for rate-limited (429) or failed (5xx) API requests, and for interrupted downloads")
+	rootCmd.Flags().DurationVar(&opts.retryMaxWait, "retry-max-wait", 30*time.Second, "Longest wait between retries")
+	rootCmd.Flags().DurationVar(&opts.timeout, "timeout", 0, "API request timeout, e.g. 30s, 10m; 0 for none (default: per model, 1m-10m)")
+	rootCmd.Flags().BoolVar(&opts.retryDifferentModel, "retry-different-model", false, "If generation fails, escalate through more robust models before giving up")
+	rootCmd.Flags().StringSliceVar(&opts.escalationLadder, "escalation-ladder", nil, "Ordered models to escalate through (default: "+strings.Join(defaultEscalationLadder, ",")+")")
+	rootCmd.Flags().BoolVarP(&opts.interactive, "interactive", "I", false, "Read prompts interactively, keeping settings between them (see /help)")
+	rootCmd.Flags().BoolVar(&opts.confirm, "confirm", false, "Ask before generating when the estimated cost is above the config's confirm_above")
+	rootCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Don't ask for confirmation")
+	rootCmd.Flags().BoolVar(&strict, "strict", false, "Treat prompt warnings, such as malformed hex colors, and flags that won't take effect as errors")
+	rootCmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "Print the API request that would be sent without calling the API")
+	rootCmd.Flags().BoolVar(&opts.noEmbed, "no-embed", false, "Don't embed the prompt, seed, and model in saved PNG and JPEG files")
+	rootCmd.Flags().BoolVar(&opts.writeMetadata, "metadata", false, "Write a <name>.json sidecar with the prompt, model, seed, and size next to each image")
+	rootCmd.Flags().BoolVar(&opts.rawOutput, "raw", false, "Print FAL's full response JSON to stdout after saving; other output goes to stderr")
+	rootCmd.Flags().BoolVar(&jsonOutput, "json", false, "Print a JSON summary of the result to stdout; other output goes to stderr")
+	rootCmd.Flags().StringVar(&saveOnError, "save-on-error", "", "On failure, write a debug bundle (request, response, flags) to this directory")
+	rootCmd.Flags().BoolVar(&opts.preview, "preview", false, "Render a preview of the result in the terminal")
+	rootCmd.Flags().BoolVar(&opts.urlOnly, "url-only", false, "Print the hosted FAL URL of each image instead of downloading it")
+	rootCmd.Flags().BoolVar(&opts.openResult, "open", false, "Open the saved image in the default viewer")
+	rootCmd.Flags().BoolVar(&opts.toClipboard, "clipboard", false, "Copy the saved image to the system clipboard")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Print only saved paths, warnings, and errors")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Also log API requests, response statuses, and timings")
+	rootCmd.PersistentFlags().BoolVar(&debugHTTP, "debug", false, "Dump every HTTP request and response to stderr (API key redacted)")
+	rootCmd.PersistentFlags().StringVar(&baseURLFlag, "base-url", "", "FAL API base URL for direct calls (default: FAL_BASE_URL or https://fal.run)")
+	rootCmd.PersistentFlags().StringVar(&queueURLFlag, "queue-url", "", "FAL queue base URL (default: FAL_QUEUE_URL, or https://queue.fal.run unless --base-url is set)")
+	rootCmd.PersistentFlags().StringVar(&proxy, "proxy", "", "Proxy URL for all requests (default: HTTP_PROXY/HTTPS_PROXY/NO_PROXY)")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "API key profile: uses FAL_KEY_<PROFILE> instead of FAL_KEY")
+	rootCmd.PersistentFlags().BoolVar(&noProgress, "no-progress", false, "Don't show progress while waiting for the API")
+	rootCmd.Flags().StringVar(&opts.nameTemplate, "name-template", "", "File name for auto-named images, from {model}, {seed}, {date}, {time}, {slug}, and {n}")
+	rootCmd.Flags().BoolVar(&opts.subdirByModel, "output-subdir-by-model", false, "Save outputs under a per-model subdirectory of the output directory")
 
 	// Models subcommand
 	modelsCmd := &cobra.Command{
 		Use:     "models",
 		Aliases: []string{"ls", "list"},
 		Short:   "List available models",
-		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Println("Available Models:")
-			fmt.Println()
-			for name, info := range models {
-				editSupport := "no edit"
-				if info.EditPath != "" {
-					editSupport = "supports edit"
-				}
-				// Check for aliases
-				var aliases []string
-				for alias, target := range modelAliases {
-					if target == name {
-						aliases = append(aliases, alias)
-					}
-				}
-				aliasStr := ""
-				if len(aliases) > 0 {
-					aliasStr = fmt.Sprintf(" (alias: %s)", strings.Join(aliases, ", "))
-				}
-				fmt.Printf("  %-17s  %s%s\n", name, editSupport, aliasStr)
-			}
-			fmt.Println()
-			fmt.Println("Use -i flag to enable edit mode (e.g., gen \"prompt\" -i image.png)")
-		},
+		Args:    cobra.NoArgs,
+		Run:     runModels,
+	}
+	modelsCmd.Flags().BoolVar(&modelsJSON, "json", false, "Print the models as JSON")
+	modelsCmd.Flags().BoolVar(&modelsPlain, "plain", false, "Print only the model names, one per line")
+	modelsCmd.Flags().BoolVar(&modelsPaths, "paths", false, "Print name<TAB>gen path<TAB>edit path, one model per line")
+
+	// Download subcommand
+	downloadCmd := &cobra.Command{
+		Use:   "download <url>",
+		Short: "Download a previously generated image by its FAL URL",
+		Args:  cobra.ExactArgs(1),
+		Run:   func(cmd *cobra.Command, args []string) { runDownload(opts, cmd, args) },
+	}
+	downloadCmd.Flags().StringArrayVarP(&opts.outputs, "output", "o", nil, "Output destination: file, directory, - for stdout, or s3://, gs:// URL (repeatable)")
+
+	// Validate subcommand
+	validateCmd := &cobra.Command{
+		Use:   "validate <manifest.yaml>",
+		Short: "Check a prompt manifest for problems without calling the API",
+		Args:  cobra.ExactArgs(1),
+		Run:   runValidate,
 	}
 
-	rootCmd.AddCommand(modelsCmd)
+	// Schema subcommand
+	schemaCmd := &cobra.Command{
+		Use:       "schema [request|response]",
+		Short:     "Print the JSON schema of API requests and responses",
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"request", "response"},
+		Run:       runSchema,
+	}
+
+	// History subcommand
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "List recent generations",
+		Args:  cobra.NoArgs,
+		Run:   runHistory,
+	}
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 20, "Number of entries to show, most recent last; 0 for all")
+	historyCmd.Flags().BoolVar(&historyJSON, "json", false, "Print raw JSON lines")
+
+	// Usage subcommand
+	usageCmd := &cobra.Command{
+		Use:   "usage",
+		Short: "Summarize generations and estimated spend from history",
+		Example: `  gen usage --since 30d
+  gen usage --by day --since 7d`,
+		Args: cobra.NoArgs,
+		Run:  runUsage,
+	}
+	usageCmd.Flags().StringVar(&usageSince, "since", "", "Only count generations newer than this, e.g. 30d, 12h (default: all)")
+	usageCmd.Flags().StringVar(&usageBy, "by", "model", "Group by model or day")
+	usageCmd.RegisterFlagCompletionFunc("by", cobra.FixedCompletions([]string{"model", "day"}, cobra.ShellCompDirectiveNoFileComp))
+
+	// Redo subcommand
+	redoCmd := &cobra.Command{
+		Use:   "redo <id>",
+		Short: "Regenerate a history entry, optionally with a new seed or model",
+		Example: `  gen redo 12
+  gen redo 12 --seed 7
+  gen redo 12 -m flux2-pro -o variation.png`,
+		Args: cobra.ExactArgs(1),
+		Run:  func(cmd *cobra.Command, args []string) { runRedo(opts, cmd, args) },
+	}
+	redoCmd.Flags().StringVarP(&redoModel, "model", "m", "", "Use a different model")
+	redoCmd.Flags().StringVarP(&redoSize, "size", "s", "", "Use a different size")
+	redoCmd.Flags().StringVarP(&redoFormat, "format", "f", "", "Use a different output format")
+	redoCmd.Flags().IntVar(&redoSeed, "seed", -1, "Use a different seed")
+	redoCmd.Flags().StringArrayVarP(&opts.outputs, "output", "o", nil, "Output destination (default: a new file in the output directory)")
+	redoCmd.Flags().StringVar(&opts.outputDir, "output-dir", "", "Directory for auto-named images, created if needed (default: ~/.gen-cli/output)")
+
+	// Sizes subcommand
+	sizesCmd := &cobra.Command{
+		Use:   "sizes",
+		Short: "List the --size values a model accepts",
+		Example: `  gen sizes
+  gen sizes -m nano-banana-pro`,
+		Args: cobra.NoArgs,
+		Run:  runSizes,
+	}
+	sizesCmd.Flags().StringVarP(&sizesModel, "model", "m", "z-turbo", "Model to describe")
+
+	// Clean subcommand
+	cleanCmd := &cobra.Command{
+		Use:   "clean",
+		Short: "Delete generated images from the output directory",
+		Long: `Delete generated images from the output directory, along with their .json
+and .seed sidecars. Only files with the default generated_ name or recorded
+in history are considered, so images saved there by hand are kept.`,
+		Example: `  gen clean --older-than 7d --dry-run
+  gen clean --keep 50 -y`,
+		Args: cobra.NoArgs,
+		Run:  func(cmd *cobra.Command, args []string) { runClean(opts, cmd, args) },
+	}
+	cleanCmd.Flags().StringVar(&cleanOlderThan, "older-than", "", "Only delete images older than this, e.g. 7d, 12h")
+	cleanCmd.Flags().IntVar(&cleanKeep, "keep", 0, "Keep this many of the most recent images")
+	cleanCmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "List what would be deleted without deleting it")
+	cleanCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Don't ask for confirmation")
+	cleanCmd.Flags().StringVar(&opts.outputDir, "output-dir", "", "Directory to clean (default: the output directory)")
+
+	// Doctor subcommand
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the API key, directories, and connection to FAL",
+		Args:  cobra.NoArgs,
+		Run:   func(cmd *cobra.Command, args []string) { runDoctor(opts, cmd, args) },
+	}
 
-	if err := rootCmd.Execute(); err != nil {
+	rootCmd.AddCommand(modelsCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(cleanCmd)
+	rootCmd.AddCommand(sizesCmd)
+	rootCmd.AddCommand(downloadCmd)
+	rootCmd.AddCommand(validateCmd)
+	rootCmd.AddCommand(schemaCmd)
+	// Batch subcommand
+	batchCmd := &cobra.Command{
+		Use:   "batch <prompts.txt>",
+		Short: "Generate an image for each line of a prompts file",
+		Long: `Generate an image for each line of a prompts file. Blank lines and lines
+starting with # are skipped. Images are named after the line's position and
+prompt, e.g. 003-a-red-fox.png.`,
+		Args: cobra.ExactArgs(1),
+		Run:  func(cmd *cobra.Command, args []string) { runBatch(opts, cmd, args) },
+	}
+	batchCmd.Flags().StringVarP(&opts.model, "model", "m", "z-turbo", "Model to use")
+	batchCmd.Flags().StringVarP(&opts.size, "size", "s", "", "Size for every image: WIDTHxHEIGHT or an aspect ratio (default: the model's default)")
+	batchCmd.Flags().StringVar(&opts.aspect, "aspect", "", "Aspect ratio for every image; takes precedence over --size")
+	batchCmd.Flags().StringVarP(&opts.format, "format", "f", "png", "Output format (png, jpeg, webp)")
+	batchCmd.Flags().StringVarP(&batchOutputDir, "output", "o", "", "Directory for the images (default: ~/.gen-cli/output)")
+	batchCmd.Flags().StringVar(&opts.outputDir, "output-dir", "", "Directory for auto-named images, created if needed (default: ~/.gen-cli/output)")
+	batchCmd.Flags().StringVar(&opts.nameTemplate, "name-template", "", "File name for each image, from {model}, {seed}, {date}, {time}, {slug}, and {n} (default: {n}-{slug})")
+	batchCmd.Flags().IntVar(&opts.batchConcurrency, "concurrency", 1, "Number of prompts to generate in parallel")
+	batchCmd.Flags().StringVar(&opts.contactSheet, "contact-sheet", "", "Also tile every image from the run into one captioned grid PNG at this path")
+	batchCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Don't ask for confirmation")
+
+	// Upscale subcommand
+	upscaleCmd := &cobra.Command{
+		Use:   "upscale <image>",
+		Short: "Upscale an image with a FAL upscaler",
+		Example: `  gen upscale photo.png
+  gen upscale photo.png --scale 4 -o photo_big.png`,
+		Args: cobra.ExactArgs(1),
+		Run:  func(cmd *cobra.Command, args []string) { runUpscale(opts, cmd, args) },
+	}
+	upscaleCmd.Flags().IntVar(&upscaleScale, "scale", 2, "Upscaling factor: 2 or 4")
+	upscaleCmd.Flags().StringArrayVarP(&opts.outputs, "output", "o", nil, "Output destination (default: <name>_x<scale>.png in the output directory)")
+	upscaleCmd.Flags().DurationVar(&opts.timeout, "timeout", 0, "API request timeout, e.g. 30s, 10m; 0 for none (default: 3m)")
+
+	// Background removal subcommand
+	rmbgCmd := &cobra.Command{
+		Use:   "rmbg <image>",
+		Short: "Remove the background from an image, writing a transparent PNG",
+		Args:  cobra.ExactArgs(1),
+		Run:   func(cmd *cobra.Command, args []string) { runRmbg(opts, cmd, args) },
+	}
+	rmbgCmd.Flags().StringVarP(&rmbgFormat, "format", "f", "png", "Output format; always png, which is needed for transparency")
+	rmbgCmd.Flags().StringArrayVarP(&opts.outputs, "output", "o", nil, "Output destination (default: <name>_nobg.png in the output directory)")
+	rmbgCmd.Flags().DurationVar(&opts.timeout, "timeout", 0, "API request timeout, e.g. 30s, 10m; 0 for none (default: 2m)")
+
+	// Auth subcommands
+	authCmd := &cobra.Command{
+		Use:   "auth",
+		Short: "Manage API keys",
+	}
+	authCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List configured API key profiles (keys are masked)",
+		Args:  cobra.NoArgs,
+		Run:   runAuthList,
+	})
+	authCmd.AddCommand(&cobra.Command{
+		Use:   "check",
+		Short: "Verify that the API key for --profile is accepted by FAL",
+		Args:  cobra.NoArgs,
+		Run:   runAuthCheck,
+	})
+	authCmd.AddCommand(&cobra.Command{
+		Use:   "login",
+		Short: "Prompt for an API key and save it to ~/.gen-cli/.env",
+		Args:  cobra.NoArgs,
+		Run:   runAuthLogin,
+	})
+
+	// Version subcommand; --version prints the same line
+	versionCmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print the version, commit, build date, and Go version",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Println(versionString())
+		},
+	}
+	rootCmd.Version = versionString()
+	rootCmd.SetVersionTemplate("{{.Version}}\n")
+
+	registerCompletions(rootCmd)
+	registerCompletions(redoCmd)
+	registerCompletions(batchCmd)
+	registerCompletions(sizesCmd)
+
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(usageCmd)
+	rootCmd.AddCommand(redoCmd)
+	rootCmd.AddCommand(batchCmd)
+	rootCmd.AddCommand(upscaleCmd)
+	rootCmd.AddCommand(rmbgCmd)
+	rootCmd.AddCommand(authCmd)
+	rootCmd.AddCommand(versionCmd)
+
+	stop := handleInterrupts()
+	err := rootCmd.Execute()
+	stop()
+	if err != nil {
 		os.Exit(1)
 	}
+	printUpdateNotice()
 }
 
 func getGenCLIDir() string {
@@ -163,271 +844,1578 @@ func getGenCLIDir() string {
 		return ""
 	}
 	dir := filepath.Join(home, ".gen-cli")
+	// On Linux, new installs follow XDG_DATA_HOME; an existing ~/.gen-cli
+	// keeps being used so nothing is stranded
+	if xdg := os.Getenv("XDG_DATA_HOME"); runtime.GOOS == "linux" && xdg != "" {
+		if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+			dir = filepath.Join(xdg, "gen-cli")
+		}
+	}
 	// Auto-create the directory if it doesn't exist
 	_ = os.MkdirAll(dir, 0755)
 	return dir
 }
 
+// getAPIKey returns the key for --profile: FAL_KEY by default, or e.g.
+// FAL_KEY_WORK for --profile work
 func getAPIKey() string {
+	keyVar := apiKeyVar(profile)
+
 	// Check environment variable first
-	if apiKey := os.Getenv("FAL_KEY"); apiKey != "" {
+	if apiKey := os.Getenv(keyVar); apiKey != "" {
+		return apiKey
+	}
+
+	// Try loading from .env in current directory
+	_ = godotenv.Load()
+	if apiKey := os.Getenv(keyVar); apiKey != "" {
 		return apiKey
 	}
 
-	// Try loading from .env in current directory
-	_ = godotenv.Load()
-	if apiKey := os.Getenv("FAL_KEY"); apiKey != "" {
-		return apiKey
+	// Try loading from ~/.gen-cli/.env
+	if genDir := getGenCLIDir(); genDir != "" {
+		envPath := filepath.Join(genDir, ".env")
+		_ = godotenv.Load(envPath)
+		if apiKey := os.Getenv(keyVar); apiKey != "" {
+			return apiKey
+		}
+	}
+
+	if keyVar != "FAL_KEY" {
+		fatalf("%s not found for profile '%s'\nSet the %s environment variable or add it to ~/.gen-cli/.env (see 'gen auth list')", keyVar, profile, keyVar)
+	}
+	fatalf("FAL_KEY not found\nSet FAL_KEY environment variable or create ~/.gen-cli/.env")
+	return ""
+}
+
+// prepareOutputDir checks that --output-dir isn't combined with -o and
+// creates the output directory if it will be used, so a bad directory fails
+// before anything is generated
+func prepareOutputDir(opts *genOptions) error {
+	if opts.outputDir != "" && len(opts.outputs) > 0 {
+		return errors.New("--output-dir cannot be combined with -o (use -o for a file path, --output-dir for a directory)")
+	}
+	if len(opts.outputs) > 0 || opts.dryRun || opts.urlOnly {
+		return nil
+	}
+	_, err := getDefaultOutputDir(opts)
+	return err
+}
+
+// getDefaultOutputDir returns the directory for auto-named images, creating
+// it if needed. The first of --output-dir, GEN_CLI_OUTPUT_DIR, and the
+// config's output_dir that is set wins, then the output directory under
+// getGenCLIDir.
+func getDefaultOutputDir(opts *genOptions) (string, error) {
+	dir := opts.outputDir
+	if dir == "" {
+		dir = expandHome(os.Getenv("GEN_CLI_OUTPUT_DIR"))
+	}
+	if dir == "" {
+		dir = config.OutputDir
+	}
+	if dir == "" {
+		genDir := getGenCLIDir()
+		if genDir == "" {
+			return "", errors.New("could not find the home directory for the default output directory; set --output-dir or GEN_CLI_OUTPUT_DIR")
+		}
+		dir = filepath.Join(genDir, "output")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+	return dir, nil
+}
+
+// withModelSubdir moves the file name of path into a subdirectory named after
+// the model, creating the directory if needed
+func withModelSubdir(path, modelName string) (string, error) {
+	dir := filepath.Join(filepath.Dir(path), modelName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+	return filepath.Join(dir, filepath.Base(path)), nil
+}
+
+// modelListing is a model as shown by `gen models`
+type modelListing struct {
+	Name         string   `json:"name"`
+	Aliases      []string `json:"aliases"`
+	Custom       bool     `json:"custom"`
+	SizeParam    string   `json:"size_param"`
+	AutoSize     bool     `json:"auto_size"`
+	GenPath      string   `json:"gen_path"`
+	EditPath     string   `json:"edit_path,omitempty"`
+	SupportsEdit bool     `json:"supports_edit"`
+}
+
+// runModels lists the models sorted by name, with their aliases, size
+// parameter, and FAL endpoints
+func runModels(cmd *cobra.Command, args []string) {
+	formats := 0
+	for _, set := range []bool{modelsJSON, modelsPlain, modelsPaths} {
+		if set {
+			formats++
+		}
+	}
+	if formats > 1 {
+		fatalf("use only one of --json, --plain, and --paths")
+	}
+
+	listings := make([]modelListing, 0, len(models))
+	for name, info := range models {
+		aliases := []string{}
+		for alias, target := range modelAliases {
+			if target == name {
+				aliases = append(aliases, alias)
+			}
+		}
+		sort.Strings(aliases)
+		listings = append(listings, modelListing{
+			Name:         name,
+			Aliases:      aliases,
+			Custom:       info.Custom,
+			SizeParam:    info.SizeParamName,
+			AutoSize:     info.SupportsAutoImgSize,
+			GenPath:      info.GenPath,
+			EditPath:     info.EditPath,
+			SupportsEdit: info.canEdit(),
+		})
+	}
+	sort.Slice(listings, func(i, j int) bool { return listings[i].Name < listings[j].Name })
+
+	switch {
+	case modelsJSON:
+		printJSON(listings)
+		return
+	case modelsPlain:
+		for _, m := range listings {
+			fmt.Println(m.Name)
+		}
+		return
+	case modelsPaths:
+		for _, m := range listings {
+			fmt.Printf("%s\t%s\t%s\n", m.Name, m.GenPath, m.EditPath)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tSIZE\tAUTO SIZE\tGEN PATH\tEDIT PATH\tNOTES")
+	for _, m := range listings {
+		autoSize := "no"
+		if m.AutoSize {
+			autoSize = "yes"
+		}
+		editPath := m.EditPath
+		if editPath == "" {
+			editPath = "-"
+		}
+		var notes []string
+		if len(m.Aliases) > 0 {
+			notes = append(notes, "alias: "+strings.Join(m.Aliases, ", "))
+		}
+		if m.Custom {
+			notes = append(notes, "custom")
+		}
+		if m.EditPath == "" && m.SupportsEdit {
+			notes = append(notes, "img2img")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", m.Name, m.SizeParam, autoSize, m.GenPath, editPath, strings.Join(notes, "; "))
+	}
+	w.Flush()
+	fmt.Println()
+	fmt.Println("Use -i flag to enable edit mode (e.g., gen \"prompt\" -i image.png)")
+}
+
+func resolveModel(name string) string {
+	if alias, ok := modelAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+func runGenerate(opts *genOptions, cmd *cobra.Command, args []string) {
+	if len(args) > 0 && opts.promptFile != "" {
+		fatalf("--prompt-file cannot be combined with a prompt argument")
+	}
+
+	// With no prompt, read one from --prompt-file or a pipe, or show help
+	if len(args) == 0 && !opts.interactive && opts.promptFile == "" {
+		if !stdinIsPiped() {
+			cmd.Help()
+			return
+		}
+		args = []string{"-"}
+	}
+
+	recordFlags(cmd)
+	applyConfig(opts, cmd)
+
+	if jsonOutput && slices.Contains(opts.outputs, stdoutDest) {
+		fatalf("--json cannot be combined with -o - (both write to stdout)")
+	}
+	if opts.rawOutput && (jsonOutput || opts.urlOnly || slices.Contains(opts.outputs, stdoutDest)) {
+		fatalf("--raw cannot be combined with --json, --url-only, or -o - (they all write to stdout)")
+	}
+	if opts.preview && (jsonOutput || opts.rawOutput || slices.Contains(opts.outputs, stdoutDest)) {
+		fatalf("--preview cannot be combined with --json, --raw, or -o - (the preview is drawn on stdout)")
+	}
+	if opts.watch {
+		switch {
+		case opts.promptFile == "":
+			fatalf("--watch needs a --prompt-file to watch")
+		case opts.interactive || opts.thenPrompt != "":
+			fatalf("--watch cannot be combined with --interactive or --then")
+		}
+	}
+	if (opts.interactive || opts.watch) && slices.ContainsFunc(opts.inputImages, isInputPattern) {
+		fatalf("a glob or directory -i cannot be combined with --interactive or --watch")
+	}
+	if len(opts.refImages) > 0 {
+		switch {
+		case len(opts.inputImages) == 0:
+			fatalf("--ref adds reference images to an edit; give the image to edit with -i")
+		case opts.interactive || opts.maskPath != "":
+			fatalf("--ref cannot be combined with --interactive or --mask")
+		case slices.ContainsFunc(opts.inputImages, isInputPattern):
+			fatalf("--ref cannot be combined with a glob or directory -i")
+		}
+		// References always follow the -i images, so @image1 is the first -i
+		opts.inputImages = append(opts.inputImages, opts.refImages...)
+	}
+	if cmd.Flags().Changed("seed-sweep") {
+		if err := checkSeedSweep(opts); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if len(opts.matrixFlags) > 0 {
+		if err := checkMatrix(opts); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if err := checkContactSheet(opts); err != nil {
+		fatalf("%v", err)
+	}
+	if opts.contactSheet != "" && (opts.interactive || opts.watch) {
+		fatalf("--contact-sheet cannot be combined with --interactive or --watch")
+	}
+	if opts.thenPrompt != "" {
+		if err := checkThen(opts); err != nil {
+			fatalf("%v", err)
+		}
+	} else if opts.thenModel != "" {
+		fatalf("--then-model only applies with --then")
+	}
+	if opts.urlOnly && opts.inlineResult {
+		fatalf("--url-only cannot be combined with --inline, which returns no URL")
+	}
+	if opts.urlOnly {
+		for _, f := range []string{"output", "output-dir", "open", "clipboard", "preview", "max-file-size", "metadata", "save-seed", "name-template", "also", "quality", "png-compression", "contact-sheet"} {
+			if cmd.Flags().Changed(f) {
+				fatalf("--url-only cannot be combined with --%s, which needs a downloaded file", f)
+			}
+		}
+	}
+
+	normalized, err := normalizeFormat(opts.format)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	opts.format = normalized
+	for i, f := range opts.alsoFormats {
+		if opts.alsoFormats[i], err = normalizeFormat(f); err != nil {
+			fatalf("--also: %v", err)
+		}
+	}
+	if slices.Contains(opts.alsoFormats, "webp") {
+		if err := checkWebPEncoder(); err != nil {
+			fatalf("--also: %v", err)
+		}
+	}
+
+	if err := validateNameTemplate(opts.nameTemplate); err != nil {
+		fatalf("%v", err)
+	}
+	if err := applyAspect(opts, cmd); err != nil {
+		fatalf("%v", err)
+	}
+	if err := prepareOutputDir(opts); err != nil {
+		fatalf("%v", err)
+	}
+
+	if opts.maxFileSize != "" {
+		limit, err := parseByteSize(opts.maxFileSize)
+		if err != nil {
+			fatalf("--max-file-size: %v", err)
+		}
+		opts.maxFileSizeBytes = limit
+	}
+	if err := parseEncodingFlags(opts, cmd); err != nil {
+		fatalf("%v", err)
+	}
+
+	if opts.numImages < 1 {
+		fatalf("--num must be at least 1")
+	}
+	if opts.maxRetries < 0 {
+		fatalf("--retries must not be negative")
+	}
+	opts.strengthSet = cmd.Flags().Changed("strength")
+	if opts.strengthSet && (opts.strength < 0 || opts.strength > 1) {
+		fatalf("--strength must be between 0 and 1")
+	}
+	if opts.safetyOn && opts.safetyOff {
+		fatalf("--safety and --no-safety cannot be combined")
+	}
+	if opts.randomSeed && cmd.Flags().Changed("seed") {
+		fatalf("--seed and --random-seed cannot be combined")
+	}
+	opts.timeoutSet = cmd.Flags().Changed("timeout")
+
+	// A dry run only needs the key if it has to translate
+	var apiKey string
+	if !opts.dryRun || opts.translate {
+		apiKey = getAPIKey()
+	}
+
+	if opts.interactive {
+		runREPL(opts, apiKey)
+		return
+	}
+	if opts.watch {
+		runWatch(opts, apiKey)
+		return
+	}
+
+	var prompt string
+	switch {
+	case opts.promptFile != "":
+		prompt, err = readPromptFile(opts.promptFile)
+	case args[0] == "-":
+		prompt, err = readPromptFromStdin()
+	default:
+		prompt = args[0]
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	files, err := expandInputs(opts.inputImages)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if files != nil {
+		runEditEach(opts, apiKey, prompt, files)
+		return
+	}
+	if len(opts.matrixFlags) > 0 {
+		runMatrix(opts, apiKey, prompt)
+		return
+	}
+
+	results, err := generate(opts, apiKey, prompt)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if opts.thenPrompt != "" {
+		results, err = editResult(opts, apiKey, results)
+		if err != nil {
+			fatalf("%v", err)
+		}
+	}
+	// Written last so its path follows the run's summary
+	if !opts.dryRun {
+		defer saveContactSheet(opts, resultImages(results))
+	}
+	if jsonOutput {
+		if len(results) > 0 {
+			printJSONResult(results)
+		}
+		return
+	}
+
+	if opts.seedSweep > 0 {
+		// A dry run saved nothing to list
+		if !opts.dryRun {
+			printSweepTable(results)
+		}
+		return
+	}
+	// --then has already listed both of its images
+	if len(results) > 1 && opts.thenPrompt == "" {
+		infof("\nGenerated %d sizes (seed %d):\n", len(results), opts.seed)
+		for _, result := range results {
+			for _, img := range result.Images {
+				for _, dest := range img.Destinations {
+					infof("  %s\n", dest)
+				}
+			}
+		}
+	}
+}
+
+// generate runs the prompt with the current flag settings, once per
+// comma-separated size, and returns what was saved. A dry run prints the
+// requests and returns no results.
+func generate(opts *genOptions, apiKey, prompt string) ([]*GenerationResult, error) {
+	resolvedModel := resolveModel(opts.model)
+	info, ok := models[resolvedModel]
+	if !ok {
+		return nil, fmt.Errorf("unknown model '%s'. Use 'gen models' to see available options.", opts.model)
+	}
+
+	// Catch sizes the model can't take before anything is sent
+	for _, sz := range splitList(opts.size) {
+		if err := validateSize(info, sz, len(opts.inputImages) > 0); err != nil {
+			return nil, fmt.Errorf("model '%s': %w", opts.model, err)
+		}
+	}
+
+	if opts.translate {
+		translated, err := translatePrompt(opts, apiKey, prompt)
+		if err != nil {
+			return nil, err
+		}
+		if translated != prompt {
+			infof("Original prompt: %s\n", prompt)
+			infof("Translated prompt: %s\n", translated)
+			prompt = translated
+		}
+	}
+
+	if opts.timeoutSet {
+		opts.requestTimeout = opts.timeout
+	} else if info.DefaultTimeout > 0 {
+		opts.requestTimeout = info.DefaultTimeout
+	} else {
+		opts.requestTimeout = defaultTimeout
+	}
+
+	// Pick the seed locally so every run can be reproduced, unless the
+	// server is explicitly allowed to choose
+	if opts.seed < 0 && !opts.randomSeed {
+		opts.seed = rand.IntN(math.MaxInt32)
+	}
+
+	isEditMode := len(opts.inputImages) > 0
+	if opts.maskPath != "" {
+		if len(opts.inputImages) != 1 {
+			return nil, errors.New("--mask needs exactly one input image (-i)")
+		}
+		if err := checkMaskDimensions(opts.maskPath, opts.inputImages[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	unused, err := checkImageRefs(prompt, len(opts.inputImages))
+	if err != nil {
+		return nil, err
+	}
+	// A single edit image rarely needs naming, so only nag about multi-reference prompts
+	if info.UsesImageRefs && len(opts.inputImages) > 1 && len(unused) > 0 {
+		var names []string
+		for _, n := range unused {
+			names = append(names, fmt.Sprintf("@image%d", n))
+		}
+		warnf("%s not referenced in the prompt; %s may weigh the images arbitrarily", strings.Join(names, ", "), opts.model)
+	}
+
+	if info.SupportsHexColors {
+		for _, token := range invalidHexColors(prompt) {
+			if strict {
+				return nil, fmt.Errorf("'%s' is not a valid hex color (use #RGB or #RRGGBB)", token)
+			}
+			warnf("'%s' is not a valid hex color (use #RGB or #RRGGBB)", token)
+		}
+	}
+
+	modelPath, err := modelPathFor(opts, info, opts.model, isEditMode)
+	if err != nil {
+		return nil, err
+	}
+
+	// Handle input images for edit mode
+	var imageURLs []string
+	opts.maskURL = ""
+	if isEditMode {
+		// With --fit, shrink every local input by the same factor to get
+		// under the megapixel budget instead of failing
+		scale := 1.0
+		if opts.fitInputs && info.MaxMegapixels == 0 {
+			if err := ignoredFlag("model '%s' has no input size limit for --fit to meet", opts.model); err != nil {
+				return nil, err
+			}
+		}
+		if opts.fitInputs && info.MaxMegapixels > 0 {
+			total, err := inputMegapixels(opts.inputImages)
+			if err != nil {
+				return nil, err
+			}
+			if total > info.MaxMegapixels {
+				// Area goes with the square of the scale; shave a little so
+				// rounding can't leave the total a hair over the limit
+				scale = math.Sqrt(info.MaxMegapixels/total) * 0.999
+			}
+		}
+		if err := checkInputLimits(info, resolvedModel, opts.inputImages, scale); err != nil {
+			return nil, err
+		}
+		if opts.maskPath != "" {
+			// The mask is scaled with the input so their dimensions still match
+			if scale < 1 && !isURL(opts.inputImages[0]) {
+				opts.maskURL, err = fittedImageDataURI(opts.maskPath, scale)
+			} else {
+				opts.maskURL, err = imageToDataURI(opts.maskPath)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read mask %s: %w", opts.maskPath, err)
+			}
+		}
+
+		for i, imgPath := range opts.inputImages {
+			var imageURL string
+			var err error
+			if scale < 1 && !isURL(imgPath) {
+				imageURL, err = fittedImageDataURI(imgPath, scale)
+			} else {
+				imageURL, err = inputImageURL(opts, imgPath)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read image %d (%s): %w", i+1, imgPath, err)
+			}
+			imageURLs = append(imageURLs, imageURL)
+		}
+		infof("Edit mode: %d input image(s)\n", len(imageURLs))
+	}
+
+	// A comma-separated --size runs the generation once per size
+	sizes := splitList(opts.size)
+	if err := confirmCost(opts, resolvedModel, opts.numImages*max(1, len(sizes), opts.seedSweep)); err != nil {
+		return nil, err
+	}
+	if opts.seedSweep > 0 {
+		return sweepSeeds(opts, apiKey, resolvedModel, info, modelPath, prompt, imageURLs)
+	}
+	if opts.dryRun {
+		if len(sizes) <= 1 {
+			sizes = []string{opts.size}
+		}
+		return nil, printDryRun(opts, info, opts.model, modelPath, prompt, sizes, imageURLs)
+	}
+	if len(sizes) <= 1 {
+		result, err := generateOne(opts, apiKey, resolvedModel, modelPath, prompt, opts.size, imageURLs, opts.outputSuffix)
+		if err != nil {
+			return nil, err
+		}
+		return []*GenerationResult{result}, nil
+	}
+
+	var results []*GenerationResult
+	for i, sz := range sizes {
+		infof("\n[%d/%d] Size %s\n", i+1, len(sizes), sz)
+		result, err := generateOne(opts, apiKey, resolvedModel, modelPath, prompt, sz, imageURLs, opts.outputSuffix+"_"+sizeSuffix(sz))
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+
+		// Hold the seed constant so compositions stay related across sizes
+		if opts.seed < 0 {
+			opts.seed = result.Seed
+		}
+	}
+	return results, nil
+}
+
+// GenerationResult describes one completed API call and its saved images
+type GenerationResult struct {
+	Model   string
+	Size    string
+	Seed    int
+	Elapsed time.Duration
+	Images  []GeneratedImage
+}
+
+// GeneratedImage is a single result image and where it was written
+type GeneratedImage struct {
+	URL          string
+	Width        int
+	Height       int
+	Destinations []string
+}
+
+// generateOne runs a single API call for the given size and saves the
+// result. suffix is appended to output file names.
+func generateOne(opts *genOptions, apiKey, resolvedModel, modelPath, prompt, requestedSize string, imageURLs []string, suffix string) (*GenerationResult, error) {
+	info := models[resolvedModel]
+	isEditMode := len(imageURLs) > 0
+
+	sizeValue := resolveSizeValue(opts, info, requestedSize, isEditMode)
+	req, err := buildRequest(opts, info, opts.model, prompt, sizeValue, imageURLs)
+	if err != nil {
+		return nil, err
+	}
+
+	infof("Using model: %s\n", modelPath)
+	if sizeValue == "auto" && !isEditMode {
+		infof("Requested size: model default\n")
+	} else if sizeValue != "" {
+		infof("Requested size: %s\n", sizeValue)
+	}
+
+	recordRequest(req)
+	if progressEnabled {
+		if eta, runs := estimateDuration(modelPath); eta > 0 {
+			basis := "your last run"
+			if runs > 1 {
+				basis = fmt.Sprintf("your last %d runs", runs)
+			}
+			infof("Estimated time: ~%s for %s based on %s\n", eta.Round(time.Second), resolvedModel, basis)
+			progressETA = eta
+			defer func() { progressETA = 0 }()
+		}
+	}
+	startTime := time.Now()
+	var response *ImageResponse
+	similarity := -1.0
+	if opts.targetSimilarity != "" {
+		response, similarity, err = generateWithSimilarity(opts, apiKey, info, modelPath, req)
+		if err != nil && response != nil {
+			warnf("%v", err)
+			err = nil
+		}
+	} else {
+		response, err = callFALAPI(opts.falClient(), apiKey, info, modelPath, req)
+		if err != nil && opts.retryDifferentModel {
+			response, resolvedModel, err = escalate(opts, apiKey, resolvedModel, prompt, requestedSize, imageURLs, err)
+			modelPath, _ = modelPathFor(opts, models[resolvedModel], resolvedModel, isEditMode)
+		}
+	}
+	elapsed := time.Since(startTime)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Images) == 0 {
+		return nil, errors.New("No images returned")
+	}
+
+	if len(response.Images) < opts.numImages {
+		warnf("requested %d images, got %d", opts.numImages, len(response.Images))
+	}
+	warnFlagged(opts, models[resolvedModel], response)
+	if opts.enhance && response.Prompt != "" && response.Prompt != prompt {
+		infof("Expanded prompt: %s\n", response.Prompt)
+	}
+
+	result := &GenerationResult{
+		Model:   resolvedModel,
+		Size:    sizeValue,
+		Seed:    response.Seed,
+		Elapsed: elapsed,
+	}
+	meta := ImageMetadata{
+		Prompt:         prompt,
+		NegativePrompt: req.NegativePrompt,
+		Model:          resolvedModel,
+		ModelPath:      modelPath,
+		Seed:           response.Seed,
+		Size:           sizeValue,
+		Images:         absPaths(opts.inputImages),
+		Format:         opts.format,
+		Steps:          req.NumInferenceSteps,
+		Guidance:       req.GuidanceScale,
+		Strength:       req.Strength,
+		CreatedAt:      time.Now(),
+	}
+	if opts.maskPath != "" {
+		meta.Mask = absPaths([]string{opts.maskPath})[0]
+	}
+	var allSaved []*SavedOutput
+	downloadStart := time.Now()
+	for i, img := range response.Images {
+		if opts.urlOnly {
+			if !jsonOutput {
+				fmt.Println(img.URL)
+			}
+			result.Images = append(result.Images, GeneratedImage{
+				URL:          img.URL,
+				Width:        img.Width,
+				Height:       img.Height,
+				Destinations: []string{},
+			})
+			continue
+		}
+
+		imgSuffix := suffix
+		name := ""
+		if opts.nameTemplate != "" {
+			name = expandNameTemplate(opts.nameTemplate, meta, i+1)
+		}
+		verb := "Downloading"
+		if isDataURI(img.URL) {
+			verb = "Saving"
+		}
+		if len(response.Images) > 1 {
+			// A template with {n} already tells the images apart
+			if !strings.Contains(opts.nameTemplate, "{n}") {
+				imgSuffix += fmt.Sprintf("_%d", i+1)
+			}
+			infof("%s image %d/%d...\n", verb, i+1, len(response.Images))
+		} else {
+			infof("%s image...\n", verb)
+		}
+
+		saved, err := saveOutputs(opts, img.URL, opts.outputs, opts.format, resolvedModel, imgSuffix, name, &meta)
+		if err != nil && isDataURI(img.URL) {
+			return nil, fmt.Errorf("failed to save image: %w", err)
+		} else if err != nil {
+			// The generation was paid for, so point at where it can still be fetched
+			return nil, fmt.Errorf("failed to save image: %w\nThe image is still available at %s\nFetch it with: gen download '%s'", err, img.URL, img.URL)
+		}
+		defer saved.cleanup()
+		allSaved = append(allSaved, saved)
+
+		for _, dest := range saved.Destinations {
+			logSaved(opts, dest)
+		}
+		if opts.saveSeed {
+			writeSeedFiles(saved.Destinations, response.Seed)
+		}
+		if opts.writeMetadata {
+			writeSidecars(saved.Destinations, meta)
+		}
+
+		// Not every model reports dimensions, so fall back to the file itself
+		width, height := img.Width, img.Height
+		if width == 0 {
+			width, height, _ = getImageDimensions(saved.LocalPath)
+		}
+		if width > 0 {
+			infof("Dimensions: %dx%d\n", width, height)
+		}
+		// Inline image data isn't a URL worth reporting
+		hostedURL := img.URL
+		if isDataURI(hostedURL) {
+			hostedURL = ""
+		}
+		result.Images = append(result.Images, GeneratedImage{
+			URL:          hostedURL,
+			Width:        width,
+			Height:       height,
+			Destinations: saved.Destinations,
+		})
+	}
+	var outputPaths []string
+	for _, img := range result.Images {
+		outputPaths = append(outputPaths, img.Destinations...)
+		if opts.urlOnly {
+			outputPaths = append(outputPaths, img.URL)
+		}
+	}
+	if err := appendHistory(HistoryEntry{ImageMetadata: meta, Outputs: outputPaths, Count: len(response.Images), ElapsedSeconds: elapsed.Seconds()}); err != nil {
+		warnf("could not update history: %v", err)
+	}
+
+	infof("Seed: %d (reproduce with --seed %d)\n", response.Seed, response.Seed)
+	if similarity >= 0 {
+		infof("Similarity: %.2f\n", similarity)
+	}
+	infof("Time: %.1fs\n", elapsed.Seconds())
+	debugf("Timing: API %.2fs, download and save %.2fs\n", elapsed.Seconds(), time.Since(downloadStart).Seconds())
+	if opts.rawOutput {
+		fmt.Println(strings.TrimSpace(string(response.Raw)))
+	}
+
+	if opts.preview {
+		for _, saved := range allSaved {
+			if err := renderPreview(saved.LocalPath); err != nil {
+				warnf("could not render preview: %v", err)
+			}
+		}
+	}
+	// The clipboard holds one image, so with several the first is copied
+	if opts.toClipboard {
+		if err := copyImageToClipboard(allSaved[0].LocalPath); err != nil {
+			warnf("could not copy to clipboard: %v", err)
+		} else {
+			infof("Copied to clipboard\n")
+		}
+	}
+	if opts.openResult {
+		for _, saved := range allSaved {
+			if saved.Temporary {
+				continue
+			}
+			if err := openInViewer(saved.LocalPath); err != nil {
+				warnf("could not open %s: %v", saved.LocalPath, err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// sizeSuffix turns a size like "16:9" into a file-name-safe "16x9"
+func sizeSuffix(s string) string {
+	return strings.ReplaceAll(s, ":", "x")
+}
+
+// modelPathFor returns the FAL path to call for the model in gen or edit mode
+func modelPathFor(opts *genOptions, info ModelInfo, name string, isEditMode bool) (string, error) {
+	if !isEditMode {
+		return info.GenPath, nil
+	}
+	if opts.maskPath != "" {
+		if info.InpaintPath == "" {
+			return "", fmt.Errorf("model '%s' does not support inpainting with --mask", name)
+		}
+		return info.InpaintPath, nil
+	}
+	if info.img2img() {
+		return info.GenPath, nil
+	}
+	if info.EditPath == "" {
+		return "", fmt.Errorf("model '%s' does not support editing.", name)
+	}
+	return info.EditPath, nil
+}
+
+// applyAspect replaces --size with --aspect when it's given. --aspect only
+// takes ratios, so explicit pixels are caught here rather than sent.
+func applyAspect(opts *genOptions, cmd *cobra.Command) error {
+	if opts.aspect == "" {
+		return nil
+	}
+	for _, a := range splitList(opts.aspect) {
+		if _, ok, _ := parseDimensions(a); ok || (a != "auto" && !strings.Contains(a, ":")) {
+			return fmt.Errorf("--aspect takes a ratio like 16:9, not '%s' (use --size for WIDTHxHEIGHT)", a)
+		}
+	}
+	if cmd.Flags().Changed("size") && opts.size != opts.aspect {
+		warnf("--aspect %s takes precedence over --size %s", opts.aspect, opts.size)
+	}
+	opts.size = opts.aspect
+	return nil
+}
+
+// resolveSizeValue determines the image size/aspect ratio to request from
+// the --size flag, falling back to a per-mode default. An explicit "auto"
+// when generating is kept, and buildRequest leaves the size to the model.
+func resolveSizeValue(opts *genOptions, info ModelInfo, requested string, isEditMode bool) string {
+	firstInput := ""
+	if len(opts.inputImages) > 0 {
+		firstInput = opts.inputImages[0]
+	}
+	return resolveSizeFor(info, requested, isEditMode, firstInput)
+}
+
+// resolveSizeFor is resolveSizeValue with the edit's first input image
+// given explicitly, for edits that run in parallel
+func resolveSizeFor(info ModelInfo, requested string, isEditMode bool, firstInput string) string {
+	var sizeValue string
+	if requested != "" && requested != "auto" {
+		sizeValue = requested
+	} else if requested == "auto" && !isEditMode {
+		sizeValue = "auto"
+	} else if isEditMode && info.SupportsAutoImgSize {
+		sizeValue = "auto"
+	} else if isEditMode && firstInput != "" {
+		// Get dimensions from first input image and find closest preset
+		width, height, err := getImageDimensions(firstInput)
+		if err == nil {
+			ratio := getClosestRatio(width, height)
+			sizeValue = ratio
+			infof("Input image: %dx%d -> using %s\n", width, height, ratio)
+		}
+	} else if !isEditMode {
+		sizeValue = info.defaultSize()
+	}
+	return sizeValue
+}
+
+// ignoredFlag reports a flag that won't take effect, such as one the chosen
+// model doesn't support: a warning, or an error under --strict
+func ignoredFlag(format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if strict {
+		return errors.New(msg)
+	}
+	warnf("%s; ignoring it", msg)
+	return nil
+}
+
+// buildRequest assembles the API request for a model from the prompt,
+// resolved size, input images, and the generation flags
+func buildRequest(opts *genOptions, info ModelInfo, name, prompt, sizeValue string, imageURLs []string) (ImageRequest, error) {
+	req := ImageRequest{
+		Prompt:       prompt,
+		OutputFormat: opts.format,
+		ImageURLs:    imageURLs,
+	}
+
+	// Explicit pixels become the nearest ratio for models that only take ratios
+	if dims, ok, err := parseDimensions(sizeValue); err != nil {
+		return req, err
+	} else if ok && info.SizeParamName == "aspect_ratio" {
+		ratio := closestAspectRatio(dims.Width, dims.Height)
+		warnf("model '%s' only accepts aspect ratios; using %s for %s", name, ratio, sizeValue)
+		sizeValue = ratio
+	}
+
+	// Generating with -s auto sends no size, so the model uses its default
+	if sizeValue == "auto" && len(imageURLs) == 0 {
+		sizeValue = ""
+	}
+
+	// Set the appropriate size parameter based on model
+	if info.SizeParamName == "aspect_ratio" {
+		// nano-banana models use aspect_ratio with ratio strings directly
+		if sizeValue != "" {
+			req.AspectRatio = sizeValue
+		}
+	} else {
+		// Other models use image_size with preset names
+		if sizeValue != "" && sizeValue != "auto" {
+			// A ratio with neither a preset nor a custom size would be sent
+			// as an invalid preset name
+			if strings.Contains(sizeValue, ":") {
+				if err := validateSize(info, sizeValue, false); err != nil {
+					return req, err
+				}
+			}
+			req.ImageSize = parseSize(sizeValue)
+		} else if sizeValue == "auto" {
+			req.ImageSize = "auto"
+		}
+	}
+	if opts.negativePrompt != "" {
+		if info.SupportsNegativePrompt {
+			req.NegativePrompt = opts.negativePrompt
+		} else if err := ignoredFlag("model '%s' does not support --negative", name); err != nil {
+			return req, err
+		}
+	}
+	if opts.steps < 0 || opts.guidance < 0 {
+		return req, errors.New("--steps and --guidance must be positive")
+	}
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{{"steps", opts.steps > 0}, {"guidance", opts.guidance > 0}} {
+		if f.set && !info.SupportsSteps {
+			if err := ignoredFlag("model '%s' does not support --%s", name, f.name); err != nil {
+				return req, err
+			}
+		}
+	}
+	if info.SupportsSteps {
+		req.NumInferenceSteps = opts.steps
+		req.GuidanceScale = opts.guidance
+	}
+	if opts.strengthSet {
+		var err error
+		switch {
+		case len(imageURLs) == 0:
+			err = ignoredFlag("--strength only applies when editing with -i")
+		case !info.SupportsStrength && !info.img2img():
+			err = ignoredFlag("model '%s' does not support --strength", name)
+		default:
+			req.Strength = &opts.strength
+		}
+		if err != nil {
+			return req, err
+		}
+	}
+	if opts.maskURL != "" && len(imageURLs) == 1 {
+		req.ImageURL, req.ImageURLs = imageURLs[0], nil
+		req.MaskURL = opts.maskURL
+	} else if info.img2img() && len(imageURLs) == 1 {
+		req.ImageURL, req.ImageURLs = imageURLs[0], nil
+	}
+	if opts.seed >= 0 {
+		req.Seed = &opts.seed
+	}
+	if opts.numImages > 1 {
+		req.NumImages = opts.numImages
+	}
+	req.SyncMode = opts.inlineResult
+	if opts.enhance {
+		switch info.ExpandPromptParam {
+		case "enable_prompt_expansion":
+			req.EnablePromptExpansion = true
+		case "expand_prompt":
+			req.ExpandPrompt = true
+		case "enhance_prompt":
+			req.EnhancePrompt = true
+		default:
+			if err := ignoredFlag("model '%s' does not support --enhance", name); err != nil {
+				return req, err
+			}
+		}
+	}
+	if opts.safetyOn || opts.safetyOff {
+		if info.SupportsSafetyChecker {
+			enabled := opts.safetyOn
+			req.EnableSafetyChecker = &enabled
+		} else if err := ignoredFlag("model '%s' has no safety checker setting for --safety/--no-safety", name); err != nil {
+			return req, err
+		}
+	}
+	if opts.safetyTol != 0 {
+		if info.MaxSafetyTolerance == 0 {
+			return req, fmt.Errorf("model '%s' does not support --safety-tolerance", name)
+		}
+		if opts.safetyTol < 1 || opts.safetyTol > info.MaxSafetyTolerance {
+			return req, fmt.Errorf("--safety-tolerance for '%s' must be between 1 and %d", name, info.MaxSafetyTolerance)
+		}
+		req.SafetyTolerance = strconv.Itoa(opts.safetyTol)
+	}
+	if len(opts.imageWeights) > 0 {
+		if err := validateImageWeights(info, name, opts.imageWeights, len(imageURLs)); err != nil {
+			return req, err
+		}
+		req.ImageWeights = opts.imageWeights
+	}
+	return req, nil
+}
+
+func runDownload(opts *genOptions, cmd *cobra.Command, args []string) {
+	imageURL := args[0]
+	parsed, err := url.Parse(imageURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		fatalf("invalid URL '%s'", imageURL)
+	}
+
+	// Name the file after the extension in the URL, if any
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(parsed.Path)), ".")
+	if ext == "" {
+		ext = "png"
+	}
+
+	saved, err := saveOutputs(opts, imageURL, opts.outputs, ext, "", "", "", nil)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	defer saved.cleanup()
+
+	for _, dest := range saved.Destinations {
+		infof("Image saved to: %s\n", dest)
+	}
+}
+
+// APIError is a non-200 response from the FAL API
+type APIError struct {
+	StatusCode int
+	Message    string
+	RequestID  string        // From the x-fal-request-id header, if present
+	RetryAfter time.Duration // From the Retry-After header, if present
+	Body       []byte        // Raw response body
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error (%d): %s", e.StatusCode, e.Message)
+}
+
+// falClient returns a FAL client with the request timeout, queueing, and
+// retries the options ask for
+func (o *genOptions) falClient() *falClient {
+	client := newFALClient()
+	client.timeout = o.requestTimeout
+	client.sync = o.useSync
+	client.maxRetries = o.maxRetries
+	client.retryMaxWait = o.retryMaxWait
+	return client
+}
+
+func callFALAPI(client *falClient, apiKey string, info ModelInfo, modelPath string, req ImageRequest) (*ImageResponse, error) {
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	body, err := runFAL(client, apiKey, info, modelPath, jsonData)
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusRequestEntityTooLarge {
+			return nil, payloadTooLargeError(apiErr, len(jsonData), req)
+		}
+		return nil, err
+	}
+
+	var imgResp ImageResponse
+	if err := json.Unmarshal(body, &imgResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	imgResp.Raw = body
+
+	return &imgResp, nil
+}
+
+// runFAL sends a JSON payload to a model, through the queue unless the
+// model or --sync requires a direct call, and returns the raw result body
+func runFAL(client *falClient, apiKey string, info ModelInfo, modelPath string, jsonData []byte) ([]byte, error) {
+	if queueURL := client.queueURL(info, modelPath); queueURL != "" && !client.sync {
+		return queueFAL(client, apiKey, queueURL, jsonData)
+	}
+	return postFAL(client, apiKey, client.endpointURL(info, modelPath), jsonData)
+}
+
+// postFAL sends a JSON payload directly to a FAL endpoint and waits for the
+// result, showing a spinner meanwhile
+func postFAL(client *falClient, apiKey, url string, jsonData []byte) ([]byte, error) {
+	return withProgress(func() ([]byte, error) {
+		return doFAL(client, apiKey, "POST", url, jsonData, client.timeout)
+	})
+}
+
+// doFAL sends an authenticated request to FAL and returns the raw response
+// body, retrying transient failures with exponential backoff. Non-2xx
+// responses are returned as *APIError.
+func doFAL(client *falClient, apiKey, method, url string, jsonData []byte, timeout time.Duration) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		body, err := doFALOnce(client, apiKey, method, url, jsonData, timeout)
+
+		var apiErr *APIError
+		if err == nil || attempt >= client.maxRetries || !errors.As(err, &apiErr) || !isRetryableStatus(apiErr.StatusCode) {
+			return body, err
+		}
+
+		wait := retryDelay(attempt, apiErr.RetryAfter, client.retryMaxWait)
+		prevStatus, _ := progressStatus.Load().(string)
+		setProgressStatus(fmt.Sprintf("API error %d, retrying in %.1fs (%d/%d)...", apiErr.StatusCode, wait.Seconds(), attempt+1, client.maxRetries))
+		if err := sleepCtx(wait); err != nil {
+			return nil, err
+		}
+		setProgressStatus(prevStatus)
+	}
+}
+
+// isRetryableStatus reports whether a status code is worth retrying. Client
+// errors like 400, 401, and 422 fail fast.
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryDelay returns how long to wait before retry number attempt+1: the
+// server's Retry-After if given, otherwise exponential backoff from one
+// second with jitter. Both are capped at maxWait unless it's 0.
+func retryDelay(attempt int, retryAfter, maxWait time.Duration) time.Duration {
+	delay := retryAfter
+	if delay <= 0 {
+		// Past 2^30s the wait is capped anyway; a larger shift would overflow
+		backoff := time.Second << min(attempt, 30)
+		// Full jitter in [backoff/2, backoff) spreads out concurrent clients
+		delay = backoff/2 + time.Duration(rand.Int64N(int64(backoff/2)))
+	}
+	if maxWait > 0 && delay > maxWait {
+		delay = maxWait
+	}
+	return delay
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// doFALOnce sends a single request to FAL without retrying. Ctrl-C aborts it.
+func doFALOnce(client *falClient, apiKey, method, url string, jsonData []byte, timeout time.Duration) ([]byte, error) {
+	return doFALContext(appCtx, client, apiKey, method, url, jsonData, timeout)
+}
+
+func doFALContext(ctx context.Context, client *falClient, apiKey, method, url string, jsonData []byte, timeout time.Duration) ([]byte, error) {
+	var reqBody io.Reader
+	if jsonData != nil {
+		reqBody = bytes.NewBuffer(jsonData)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if jsonData != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpReq.Header.Set("Authorization", "Key "+apiKey)
+
+	if jsonData != nil {
+		debugf("%s %s (%d byte body)\n", method, url, len(jsonData))
+	} else {
+		debugf("%s %s\n", method, url)
+	}
+	start := time.Now()
+	resp, err := client.http(timeout).Do(httpReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errInterrupted
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, fmt.Errorf("API request timed out after %s (use --timeout to allow longer, 0 for no limit)", timeout)
+		}
+		return nil, fmt.Errorf("API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
-
-	// Try loading from ~/.gen-cli/.env
-	if genDir := getGenCLIDir(); genDir != "" {
-		envPath := filepath.Join(genDir, ".env")
-		_ = godotenv.Load(envPath)
-		if apiKey := os.Getenv("FAL_KEY"); apiKey != "" {
-			return apiKey
+	debugf("  -> %s, %d bytes in %.2fs\n", resp.Status, len(body), time.Since(start).Seconds())
+
+	// The queue status endpoint answers 202 while a request is pending
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    parseErrorDetail(body),
+			RequestID:  resp.Header.Get("x-fal-request-id"),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			Body:       body,
 		}
 	}
 
-	fmt.Fprintln(os.Stderr, "Error: FAL_KEY not found")
-	fmt.Fprintln(os.Stderr, "Set FAL_KEY environment variable or create ~/.gen-cli/.env")
-	os.Exit(1)
-	return ""
+	return body, nil
 }
 
-func getDefaultOutputPath(format string) string {
-	genDir := getGenCLIDir()
-	if genDir == "" {
-		return fmt.Sprintf("generated_%d.%s", time.Now().Unix(), format)
+// withProgress runs fn while showing the spinner. The spinner has fully
+// stopped by the time it returns, whether fn succeeded, failed, or timed out.
+func withProgress(fn func() ([]byte, error)) ([]byte, error) {
+	if !progressEnabled {
+		return fn()
 	}
+	setProgressStatus("Processing...")
+	done := make(chan bool)
+	stopped := make(chan struct{})
+	go func() {
+		showProgress(done)
+		close(stopped)
+	}()
 
-	outputDir := filepath.Join(genDir, "output")
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Sprintf("generated_%d.%s", time.Now().Unix(), format)
-	}
+	body, err := fn()
 
-	return filepath.Join(outputDir, fmt.Sprintf("generated_%d.%s", time.Now().Unix(), format))
+	done <- err == nil
+	<-stopped
+	return body, err
 }
 
-func resolveModel(name string) string {
-	if alias, ok := modelAliases[name]; ok {
-		return alias
+// parseErrorDetail extracts the human-readable message from a FAL error body
+func parseErrorDetail(body []byte) string {
+	// Try parsing as detailed error array
+	var detailedErr struct {
+		Detail []struct {
+			Msg  string `json:"msg"`
+			Type string `json:"type"`
+		} `json:"detail"`
 	}
-	return name
-}
-
-func runGenerate(cmd *cobra.Command, args []string) {
-	// If no prompt provided, show help
-	if len(args) == 0 {
-		cmd.Help()
-		return
+	if json.Unmarshal(body, &detailedErr) == nil && len(detailedErr.Detail) > 0 {
+		return detailedErr.Detail[0].Msg
 	}
 
-	prompt := args[0]
-	apiKey := getAPIKey()
-
-	resolvedModel := resolveModel(model)
-	info, ok := models[resolvedModel]
-	if !ok {
-		fmt.Fprintf(os.Stderr, "Error: unknown model '%s'. Use 'gen models' to see available options.\n", model)
-		os.Exit(1)
+	// Try parsing as simple error
+	var simpleErr struct {
+		Detail string `json:"detail"`
+	}
+	if json.Unmarshal(body, &simpleErr) == nil && simpleErr.Detail != "" {
+		return simpleErr.Detail
 	}
 
-	isEditMode := len(inputImages) > 0
+	return string(body)
+}
 
-	// Determine model path
-	var modelPath string
-	if isEditMode {
-		if info.EditPath == "" {
-			fmt.Fprintf(os.Stderr, "Error: model '%s' does not support editing.\n", model)
-			os.Exit(1)
+// validateImageWeights checks --image-weight values against the model and inputs
+func validateImageWeights(info ModelInfo, name string, weights []float64, numImages int) error {
+	if !info.SupportsImageWeights {
+		return fmt.Errorf("model '%s' does not support --image-weight", name)
+	}
+	if len(weights) != numImages {
+		return fmt.Errorf("got %d --image-weight value(s) for %d input image(s); provide one per -i and --ref image", len(weights), numImages)
+	}
+	for i, w := range weights {
+		if w < 0 || w > 1 {
+			return fmt.Errorf("--image-weight %g for image %d is out of range (0-1)", w, i+1)
 		}
-		modelPath = info.EditPath
-	} else {
-		modelPath = info.GenPath
 	}
+	return nil
+}
 
-	// Determine image size/aspect ratio
-	var sizeValue string
-	if size != "" && size != "auto" {
-		sizeValue = size
-	} else if isEditMode && info.SupportsAutoImgSize {
-		sizeValue = "auto"
-	} else if isEditMode && len(inputImages) > 0 {
-		// Get dimensions from first input image and find closest preset
-		width, height, err := getImageDimensions(inputImages[0])
-		if err == nil {
-			ratio := getClosestRatio(width, height)
-			sizeValue = ratio
-			fmt.Printf("Input image: %dx%d -> using %s\n", width, height, ratio)
-		}
-	} else if !isEditMode {
-		sizeValue = "4:3"
+// checkMaskDimensions makes sure a --mask lines up pixel for pixel with the
+// input image. A URL input can't be measured locally, so it isn't checked.
+func checkMaskDimensions(mask, input string) error {
+	maskW, maskH, err := getImageDimensions(mask)
+	if err != nil {
+		return fmt.Errorf("failed to read mask %s: %w", mask, err)
+	}
+	if isURL(input) {
+		return nil
+	}
+	inW, inH, err := getImageDimensions(input)
+	if err != nil {
+		return fmt.Errorf("failed to read image %s: %w", input, err)
 	}
+	if maskW != inW || maskH != inH {
+		return fmt.Errorf("mask is %dx%d but the input image is %dx%d; they must match", maskW, maskH, inW, inH)
+	}
+	return nil
+}
 
-	// Build request
-	req := ImageRequest{
-		Prompt:       prompt,
-		OutputFormat: format,
+// checkInputLimits enforces the model's image count and total megapixel
+// limits, with local inputs resized by scale. URL inputs count toward the
+// image limit only, since their dimensions aren't known locally.
+func checkInputLimits(info ModelInfo, name string, images []string, scale float64) error {
+	if info.img2img() && len(images) > 1 {
+		return fmt.Errorf("%s takes a single input image for image-to-image, got %d", name, len(images))
+	}
+	if info.MaxImages > 0 && len(images) > info.MaxImages {
+		return fmt.Errorf("%s allows up to %d input images, got %d", name, info.MaxImages, len(images))
+	}
+	if info.MaxMegapixels == 0 {
+		return nil
 	}
 
-	// Set the appropriate size parameter based on model
-	if info.SizeParamName == "aspect_ratio" {
-		// nano-banana models use aspect_ratio with ratio strings directly
-		if sizeValue != "" {
-			req.AspectRatio = sizeValue
+	total, err := inputMegapixels(images)
+	if err != nil {
+		return err
+	}
+	total *= scale * scale
+	if total > info.MaxMegapixels {
+		return fmt.Errorf("%s allows up to %gMP total, got %.1fMP", name, info.MaxMegapixels, total)
+	}
+	return nil
+}
+
+// inputMegapixels sums the megapixels of the local input images
+func inputMegapixels(images []string) (float64, error) {
+	var total float64
+	for _, img := range images {
+		if isURL(img) {
+			continue
 		}
-	} else {
-		// Other models use image_size with preset names
-		if sizeValue != "" && sizeValue != "auto" {
-			req.ImageSize = parseSize(sizeValue)
-		} else if sizeValue == "auto" {
-			req.ImageSize = "auto"
+		width, height, err := getImageDimensions(img)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read image %s: %w", img, err)
 		}
+		total += float64(width*height) / 1e6
 	}
-	if seed >= 0 {
-		req.Seed = &seed
-	}
+	return total, nil
+}
 
-	// Handle input images for edit mode
-	if isEditMode {
-		var imageURLs []string
-		for i, imgPath := range inputImages {
-			dataURI, err := imageToDataURI(imgPath)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error reading image %d (%s): %v\n", i+1, imgPath, err)
-				os.Exit(1)
+// imageRefPattern matches @imageN references to input images in a prompt
+var imageRefPattern = regexp.MustCompile(`@image(\d+)`)
+
+// checkImageRefs verifies that every @imageN in the prompt names one of the
+// numImages inputs, and returns the 1-based indices of inputs never referenced
+func checkImageRefs(prompt string, numImages int) ([]int, error) {
+	referenced := map[int]bool{}
+	for _, m := range imageRefPattern.FindAllStringSubmatch(prompt, -1) {
+		n, _ := strconv.Atoi(m[1])
+		if n < 1 || n > numImages {
+			if numImages == 0 {
+				return nil, fmt.Errorf("prompt references @image%s but no input images were given (use -i)", m[1])
 			}
-			imageURLs = append(imageURLs, dataURI)
+			return nil, fmt.Errorf("prompt references @image%s but only %d input image(s) were given", m[1], numImages)
 		}
-		req.ImageURLs = imageURLs
-		fmt.Printf("Edit mode: %d input image(s)\n", len(imageURLs))
+		referenced[n] = true
 	}
 
-	fmt.Printf("Using model: %s\n", modelPath)
-	if sizeValue != "" {
-		fmt.Printf("Requested size: %s\n", sizeValue)
+	var unused []int
+	for i := 1; i <= numImages; i++ {
+		if !referenced[i] {
+			unused = append(unused, i)
+		}
 	}
+	return unused, nil
+}
 
-	startTime := time.Now()
-	response, err := callFALAPI(apiKey, modelPath, req)
-	elapsed := time.Since(startTime)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
+// hexTokenPattern matches #-prefixed tokens that may be meant as color codes
+var hexTokenPattern = regexp.MustCompile(`#[0-9A-Za-z]+`)
 
-	if len(response.Images) == 0 {
-		fmt.Fprintln(os.Stderr, "Error: No images returned")
-		os.Exit(1)
-	}
+// hexColorPattern matches a valid #RGB or #RRGGBB color code
+var hexColorPattern = regexp.MustCompile(`^#([0-9A-Fa-f]{3}|[0-9A-Fa-f]{6})$`)
 
-	outPath := output
-	if outPath == "" {
-		outPath = getDefaultOutputPath(format)
-	} else {
-		// Check if output is a directory
-		if info, err := os.Stat(outPath); err == nil && info.IsDir() {
-			outPath = filepath.Join(outPath, fmt.Sprintf("generated_%d.%s", time.Now().Unix(), format))
+// invalidHexColors returns the #-prefixed tokens in prompt that aren't valid color codes
+func invalidHexColors(prompt string) []string {
+	var invalid []string
+	for _, token := range hexTokenPattern.FindAllString(prompt, -1) {
+		if !hexColorPattern.MatchString(token) {
+			invalid = append(invalid, token)
 		}
 	}
+	return invalid
+}
 
-	fmt.Println("Downloading image...")
-	if err := downloadImage(response.Images[0].URL, outPath); err != nil {
-		fmt.Fprintf(os.Stderr, "Error saving image: %v\n", err)
-		os.Exit(1)
+// payloadTooLargeError explains a 413 response, which in practice means the
+// base64-encoded input images pushed the request over FAL's size limit
+func payloadTooLargeError(apiErr *APIError, payloadSize int, req ImageRequest) error {
+	// Single-image and inpainting edits send image_url and mask_url
+	numImages := len(req.ImageURLs)
+	for _, u := range []string{req.ImageURL, req.MaskURL} {
+		if u != "" {
+			numImages++
+		}
 	}
-
-	fmt.Printf("Image saved to: %s\n", outPath)
-	if response.Images[0].Width > 0 {
-		fmt.Printf("Dimensions: %dx%d\n", response.Images[0].Width, response.Images[0].Height)
+	var b strings.Builder
+	fmt.Fprintf(&b, "request payload too large (~%s", formatBytes(int64(payloadSize)))
+	if numImages > 0 {
+		fmt.Fprintf(&b, " with %d input image(s)", numImages)
+	}
+	b.WriteString(")\n\nTry one of the following:\n")
+	if len(req.ImageURLs) > 1 {
+		b.WriteString("  - pass fewer -i images per request\n")
 	}
-	fmt.Printf("Seed: %d\n", response.Seed)
-	fmt.Printf("Time: %.1fs\n", elapsed.Seconds())
+	b.WriteString("  - host the input images and pass their https:// URLs to -i instead\n")
+	b.WriteString("  - downscale or recompress the input images (e.g. to JPEG under 2MP; --fit does this for models with a megapixel limit)\n")
+	b.WriteString("  - crop input images to the region that matters for the edit")
+	apiErr.Message = b.String()
+	return apiErr
 }
 
-func callFALAPI(apiKey, modelPath string, req ImageRequest) (*ImageResponse, error) {
-	url := fmt.Sprintf("%s/%s", falBaseURL, modelPath)
-
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+// formatBytes renders a byte count as a short human-readable string
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
 	}
-
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
 	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Key "+apiKey)
+// progressStatus is the label shown next to the spinner
+var progressStatus atomic.Value
 
-	done := make(chan bool)
-	go showProgress(done)
+// progressEnabled turns progress off for --no-progress, or when several
+// requests run at once
+var progressEnabled = true
 
-	client := &http.Client{Timeout: 5 * time.Minute}
-	resp, err := client.Do(httpReq)
+// progressETA is the expected duration shown next to the elapsed time, or 0
+// when there's no history to estimate from
+var progressETA time.Duration
 
-	done <- true
-	fmt.Println()
+func setProgressStatus(status string) {
+	progressStatus.Store(status)
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("API request failed: %w", err)
+// showProgress displays the current status with the elapsed time until a
+// result is sent on done: true for success, false for failure. On a
+// terminal it animates a spinner in place; otherwise, or with --verbose or
+// --debug, it prints a plain line whenever the status changes, and every
+// plainProgressInterval while it doesn't, so logs stay readable.
+func showProgress(done chan bool) {
+	start := time.Now()
+	if !isTerminal(msgOut) || currentLogLevel >= levelVerbose || debugHTTP {
+		showPlainProgress(done, start)
+		return
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
+	frames := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
 
-	if resp.StatusCode != http.StatusOK {
-		// Try parsing as detailed error array
-		var detailedErr struct {
-			Detail []struct {
-				Msg  string `json:"msg"`
-				Type string `json:"type"`
-			} `json:"detail"`
-		}
-		if json.Unmarshal(body, &detailedErr) == nil && len(detailedErr.Detail) > 0 {
-			return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, detailedErr.Detail[0].Msg)
-		}
+	i := 0
+	width := 0
+	for {
+		// Pad to the longest line so far so shorter labels don't leave residue
+		line := fmt.Sprintf("%s %s (%s)", frames[i%len(frames)], progressStatus.Load(), formatProgressTime(start))
+		width = max(width, utf8.RuneCountInString(line))
+		fmt.Fprintf(msgOut, "\r%-*s", width, line)
+		i++
 
-		// Try parsing as simple error
-		var simpleErr struct {
-			Detail string `json:"detail"`
-		}
-		if json.Unmarshal(body, &simpleErr) == nil && simpleErr.Detail != "" {
-			return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, simpleErr.Detail)
+		select {
+		case ok := <-done:
+			if ok {
+				fmt.Fprintf(msgOut, "\r%-*s\n", width, "✓ Complete! ("+formatElapsed(start)+")")
+			} else if appCtx.Err() != nil {
+				fmt.Fprintf(msgOut, "\r%-*s\n", width, "✗ Cancelled ("+formatElapsed(start)+")")
+			} else {
+				fmt.Fprintf(msgOut, "\r%-*s\n", width, "✗ Failed ("+formatElapsed(start)+")")
+			}
+			return
+		case <-ticker.C:
 		}
-
-		return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, string(body))
 	}
+}
 
-	var imgResp ImageResponse
-	if err := json.Unmarshal(body, &imgResp); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
-	}
+const plainProgressInterval = 10 * time.Second
 
-	return &imgResp, nil
-}
+func showPlainProgress(done chan bool, start time.Time) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
-func showProgress(done chan bool) {
-	frames := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
-	i := 0
+	var last any
+	lastPrinted := start
 	for {
+		status := progressStatus.Load()
+		if status != last || time.Since(lastPrinted) >= plainProgressInterval {
+			fmt.Fprintf(msgOut, "%s (%s)\n", status, formatProgressTime(start))
+			last, lastPrinted = status, time.Now()
+		}
+
 		select {
-		case <-done:
-			fmt.Print("\r✓ Complete!          ")
+		case ok := <-done:
+			if ok {
+				fmt.Fprintf(msgOut, "Complete (%s)\n", formatElapsed(start))
+			} else if appCtx.Err() != nil {
+				fmt.Fprintf(msgOut, "Cancelled (%s)\n", formatElapsed(start))
+			} else {
+				fmt.Fprintf(msgOut, "Failed (%s)\n", formatElapsed(start))
+			}
 			return
-		default:
-			fmt.Printf("\r%s Processing...", frames[i%len(frames)])
-			i++
-			time.Sleep(100 * time.Millisecond)
+		case <-ticker.C:
 		}
 	}
 }
 
+// formatElapsed renders the time since start to the second, e.g. "1m5s"
+func formatElapsed(start time.Time) string {
+	return time.Since(start).Round(time.Second).String()
+}
+
+// formatProgressTime renders the elapsed time, followed by the estimate
+// while the run hasn't yet gone past it, e.g. "5s / ~18s"
+func formatProgressTime(start time.Time) string {
+	if progressETA > 0 && time.Since(start) < progressETA {
+		return formatElapsed(start) + " / ~" + progressETA.Round(time.Second).String()
+	}
+	return formatElapsed(start)
+}
+
+// isTerminal reports whether w is attached to a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// getImageDimensions returns an image's width and height as displayed, so a
+// JPEG rotated a quarter turn by its EXIF orientation has them swapped
 func getImageDimensions(imagePath string) (int, int, error) {
 	file, err := os.Open(imagePath)
 	if err != nil {
@@ -435,11 +2423,17 @@ func getImageDimensions(imagePath string) (int, int, error) {
 	}
 	defer file.Close()
 
-	config, _, err := image.DecodeConfig(file)
+	config, format, err := image.DecodeConfig(file)
 	if err != nil {
 		return 0, 0, err
 	}
 
+	// Orientations 5-8 include a 90° rotation
+	if format == "jpeg" {
+		if _, err := file.Seek(0, io.SeekStart); err == nil && jpegOrientation(file) >= 5 {
+			return config.Height, config.Width, nil
+		}
+	}
 	return config.Width, config.Height, nil
 }
 
@@ -452,6 +2446,14 @@ var ratioToPreset = map[string]string{
 	"16:9": "landscape_16_9",
 }
 
+// Ratios with no image_size preset. For image_size models these are sent as
+// an explicit width and height instead (see ratioImageSize).
+var customSizeRatios = []string{"21:9", "3:2", "5:4", "4:5", "2:3"}
+
+// customSizePixels is the area custom ratios are sized to, about the same as
+// the 1024x1024 square_hd preset
+const customSizePixels = 1024 * 1024
+
 // Ratios supported by aspect_ratio parameter (nano-banana models)
 // These use the ratio string directly, no conversion needed
 var aspectRatioSupported = map[string]bool{
@@ -475,21 +2477,127 @@ var aspectPresets = []struct {
 }{
 	{"portrait_16_9", 9.0 / 16.0},  // 0.5625
 	{"portrait_4_3", 3.0 / 4.0},    // 0.75
-	{"square_hd", 1.0},              // 1.0
+	{"square_hd", 1.0},             // 1.0
 	{"landscape_4_3", 4.0 / 3.0},   // 1.333
 	{"landscape_16_9", 16.0 / 9.0}, // 1.778
 }
 
-// parseSize converts user-friendly size (ratio or preset) to API preset name
-func parseSize(s string) string {
+// parseSize converts user-friendly size (ratio or preset) to the image_size
+// value to send: a preset name, or an ImageSize for ratios without a preset
+func parseSize(s string) interface{} {
 	// Check if it's a ratio like "16:9"
 	if preset, ok := ratioToPreset[s]; ok {
 		return preset
 	}
+	if slices.Contains(customSizeRatios, s) {
+		if imgSize, ok := ratioImageSize(s); ok {
+			return imgSize
+		}
+	}
+	if dims, ok, err := parseDimensions(s); ok && err == nil {
+		return dims
+	}
 	// Otherwise assume it's already a preset name or "auto"
 	return s
 }
 
+// ratioImageSize computes a width and height for a "W:H" ratio at about
+// customSizePixels, rounded to multiples of 16 as the models expect
+func ratioImageSize(ratio string) (ImageSize, bool) {
+	ws, hs, ok := strings.Cut(ratio, ":")
+	if !ok {
+		return ImageSize{}, false
+	}
+	w, err1 := strconv.Atoi(ws)
+	h, err2 := strconv.Atoi(hs)
+	if err1 != nil || err2 != nil || w <= 0 || h <= 0 {
+		return ImageSize{}, false
+	}
+	scale := math.Sqrt(customSizePixels / float64(w*h))
+	round16 := func(x float64) int { return int(math.Round(x/16)) * 16 }
+	return ImageSize{Width: round16(float64(w) * scale), Height: round16(float64(h) * scale)}, true
+}
+
+// Bounds for explicit WIDTHxHEIGHT sizes
+const (
+	minImageDimension = 64
+	maxImageDimension = 4096
+)
+
+// parseDimensions reads an explicit size like "1536x640". ok is false if s
+// isn't in that form; err is set if it is but a dimension is out of range.
+func parseDimensions(s string) (dims ImageSize, ok bool, err error) {
+	ws, hs, found := strings.Cut(strings.ToLower(s), "x")
+	if !found {
+		return dims, false, nil
+	}
+	w, err1 := strconv.Atoi(ws)
+	h, err2 := strconv.Atoi(hs)
+	if err1 != nil || err2 != nil {
+		return dims, false, nil
+	}
+	if w < minImageDimension || h < minImageDimension || w > maxImageDimension || h > maxImageDimension {
+		return dims, true, fmt.Errorf("invalid size '%s': width and height must be between %d and %d", s, minImageDimension, maxImageDimension)
+	}
+	return ImageSize{Width: w, Height: h}, true, nil
+}
+
+// closestAspectRatio returns the aspect_ratio value nearest to width/height
+func closestAspectRatio(width, height int) string {
+	target := float64(width) / float64(height)
+	ratios := make([]string, 0, len(aspectRatioSupported))
+	for ratio := range aspectRatioSupported {
+		ratios = append(ratios, ratio)
+	}
+	sort.Strings(ratios)
+
+	closest, closestDiff := "1:1", math.Inf(1)
+	for _, ratio := range ratios {
+		var w, h int
+		if _, err := fmt.Sscanf(ratio, "%d:%d", &w, &h); err != nil {
+			continue // "auto"
+		}
+		if diff := abs(target - float64(w)/float64(h)); diff < closestDiff {
+			closest, closestDiff = ratio, diff
+		}
+	}
+	return closest
+}
+
+// validateSize checks that a --size value is one the model accepts. "auto"
+// is always allowed since it resolves to a concrete size when unsupported.
+func validateSize(info ModelInfo, s string, isEditMode bool) error {
+	if s == "auto" {
+		return nil
+	}
+	if _, ok, err := parseDimensions(s); ok {
+		return err
+	}
+
+	var valid []string
+	if info.SizeParamName == "aspect_ratio" {
+		if aspectRatioSupported[s] {
+			return nil
+		}
+		for ratio := range aspectRatioSupported {
+			valid = append(valid, ratio)
+		}
+	} else {
+		for ratio, preset := range ratioToPreset {
+			if s == ratio || s == preset {
+				return nil
+			}
+			valid = append(valid, ratio)
+		}
+		if slices.Contains(customSizeRatios, s) {
+			return nil
+		}
+		valid = append(valid, customSizeRatios...)
+	}
+	sort.Strings(valid)
+	return fmt.Errorf("invalid size '%s' (valid: %s, or WIDTHxHEIGHT)", s, strings.Join(valid, ", "))
+}
+
 func getClosestPreset(width, height int) string {
 	if width == 0 || height == 0 {
 		return "square_hd"
@@ -529,6 +2637,46 @@ func abs(x float64) float64 {
 	return x
 }
 
+// isURL reports whether an input image is a remote http(s) URL
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// inputImageURL returns the value to send in image_urls for an -i input.
+// Remote URLs are passed through for FAL to fetch unless --inline-urls is
+// set; local files are sent as data URIs.
+func inputImageURL(opts *genOptions, input string) (string, error) {
+	if !isURL(input) {
+		return imageToDataURI(input)
+	}
+	if !opts.inlineURLs {
+		return input, nil
+	}
+	return remoteImageToDataURI(input)
+}
+
+// remoteImageToDataURI downloads an image and encodes it as a data URI
+func remoteImageToDataURI(imageURL string) (string, error) {
+	resp, err := httpGet(imageURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	contentType := resp.Header.Get("Content-Type")
+	if resp.StatusCode != http.StatusOK || !isImageContentType(contentType) {
+		return "", fmt.Errorf("not an image: got %s %d", contentType, resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	encoded := base64.StdEncoding.EncodeToString(data)
+	return fmt.Sprintf("data:%s;base64,%s", mediaType, encoded), nil
+}
+
 func imageToDataURI(imagePath string) (string, error) {
 	data, err := os.ReadFile(imagePath)
 	if err != nil {
@@ -554,19 +2702,157 @@ func imageToDataURI(imagePath string) (string, error) {
 	return fmt.Sprintf("data:%s;base64,%s", mimeType, encoded), nil
 }
 
+// isDataURI reports whether s holds inline data rather than a URL
+func isDataURI(s string) bool {
+	return strings.HasPrefix(s, "data:")
+}
+
+// decodeDataURI returns the bytes of a base64 data URI
+func decodeDataURI(uri string) ([]byte, error) {
+	header, encoded, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return nil, errors.New("malformed data URI in response")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("malformed data URI in response: %w", err)
+	}
+	return data, nil
+}
+
+// saveDataURI writes the image held in a data URI (from --inline) to
+// outputPath, with the same checks as a download
+func saveDataURI(uri, outputPath string) error {
+	data, err := decodeDataURI(uri)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputPath, data, 0644); err != nil {
+		return err
+	}
+	if err := checkImageFile(outputPath); err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+	return nil
+}
+
+// errNotImage marks a download that can't succeed by retrying
+var errNotImage = errors.New("not an image")
+
 func downloadImage(url, outputPath string) error {
-	resp, err := http.Get(url)
+	resp, err := httpGet(url)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	// Some storage serves images as application/octet-stream or with no
+	// type at all, so trust the bytes over the header
+	body := bufio.NewReader(resp.Body)
+	contentType := resp.Header.Get("Content-Type")
+	if resp.StatusCode == http.StatusOK && !isImageContentType(contentType) {
+		head, _ := body.Peek(512)
+		if sniffed := http.DetectContentType(head); isImageContentType(sniffed) {
+			contentType = sniffed
+		}
+	}
+
+	// Expired result URLs come back as HTML error pages; refuse to save those
+	// under an image extension
+	if resp.StatusCode != http.StatusOK || !isImageContentType(contentType) {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if mediaType == "" {
+			mediaType = "no content type"
+		}
+		if isRetryableStatus(resp.StatusCode) {
+			return fmt.Errorf("download failed: got %s %d", mediaType, resp.StatusCode)
+		}
+		return fmt.Errorf("URL expired or %w: got %s %d", errNotImage, mediaType, resp.StatusCode)
+	}
+
 	file, err := os.Create(outputPath)
 	if err != nil {
 		return err
 	}
+	var dst io.Writer = file
+	if progressEnabled && isTerminal(msgOut) && currentLogLevel < levelVerbose && !debugHTTP {
+		progress := newDownloadProgress(resp.ContentLength)
+		defer progress.finish()
+		dst = io.MultiWriter(file, progress)
+	}
+	if _, err = io.Copy(dst, body); err != nil {
+		err = fmt.Errorf("download incomplete: %w", err)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = checkImageFile(outputPath)
+	}
+	// Don't leave a truncated or corrupt image behind
+	if err != nil {
+		os.Remove(outputPath)
+	}
+	return err
+}
+
+// downloadProgress is an io.Writer that counts downloaded bytes and redraws
+// a progress line in place. Nothing is drawn for downloads that finish
+// within the first redraw interval.
+type downloadProgress struct {
+	total    int64 // From Content-Length; -1 if unknown
+	written  int64
+	lastDraw time.Time
+	width    int
+}
+
+const downloadRedrawInterval = 100 * time.Millisecond
+
+func newDownloadProgress(total int64) *downloadProgress {
+	return &downloadProgress{total: total, lastDraw: time.Now()}
+}
+
+func (p *downloadProgress) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	if time.Since(p.lastDraw) >= downloadRedrawInterval {
+		p.lastDraw = time.Now()
+		line := "Downloading... " + formatBytes(p.written)
+		if p.total > 0 {
+			line = fmt.Sprintf("Downloading... %d%% (%s / %s)", p.written*100/p.total, formatBytes(p.written), formatBytes(p.total))
+		}
+		p.width = max(p.width, utf8.RuneCountInString(line))
+		fmt.Fprintf(msgOut, "\r%-*s", p.width, line)
+	}
+	return len(b), nil
+}
+
+// finish clears the progress line, if one was drawn
+func (p *downloadProgress) finish() {
+	if p.width > 0 {
+		fmt.Fprintf(msgOut, "\r%-*s\r", p.width, "")
+	}
+}
+
+// checkImageFile confirms a downloaded file starts with a decodable image
+// header
+func checkImageFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
 	defer file.Close()
+	if _, _, err := image.DecodeConfig(file); err != nil {
+		return fmt.Errorf("downloaded file is %w: %v", errNotImage, err)
+	}
+	return nil
+}
 
-	_, err = io.Copy(file, resp.Body)
-	return err
+// isImageContentType reports whether a Content-Type header describes image data
+func isImageContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "image/")
 }
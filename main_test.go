@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFALClient returns a client that sends every request to srv
+func testFALClient(t *testing.T, srv *httptest.Server) *falClient {
+	t.Helper()
+	progressEnabled = false
+	return &falClient{
+		http:         func(timeout time.Duration) httpDoer { return &http.Client{Timeout: timeout} },
+		baseURL:      srv.URL,
+		queueBaseURL: srv.URL + "/queue",
+		timeout:      10 * time.Second,
+		retryMaxWait: time.Millisecond,
+	}
+}
+
+func TestCallFALAPIDirect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/fal-ai/test-model" {
+			t.Errorf("got %s %s, want POST /fal-ai/test-model", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Key test-key" {
+			t.Errorf("Authorization = %q, want %q", got, "Key test-key")
+		}
+		var req ImageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		if req.Prompt != "a cat" {
+			t.Errorf("prompt = %q, want %q", req.Prompt, "a cat")
+		}
+		io.WriteString(w, `{"images":[{"url":"https://example.com/cat.png","width":512,"height":512}],"seed":42}`)
+	}))
+	defer srv.Close()
+
+	client := testFALClient(t, srv)
+	client.sync = true
+	resp, err := callFALAPI(client, "test-key", ModelInfo{}, "fal-ai/test-model", ImageRequest{Prompt: "a cat"})
+	if err != nil {
+		t.Fatalf("callFALAPI: %v", err)
+	}
+	if len(resp.Images) != 1 || resp.Images[0].URL != "https://example.com/cat.png" {
+		t.Errorf("images = %+v, want one at https://example.com/cat.png", resp.Images)
+	}
+	if resp.Seed != 42 {
+		t.Errorf("seed = %d, want 42", resp.Seed)
+	}
+}
+
+func TestCallFALAPIQueue(t *testing.T) {
+	var srv *httptest.Server
+	var polls int
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/queue/fal-ai/test-model":
+			io.WriteString(w, `{"request_id":"req-1","status_url":"`+srv.URL+`/status","response_url":"`+srv.URL+`/response"}`)
+		case r.Method == "GET" && r.URL.Path == "/status":
+			polls++
+			w.WriteHeader(http.StatusAccepted)
+			io.WriteString(w, `{"status":"COMPLETED"}`)
+		case r.Method == "GET" && r.URL.Path == "/response":
+			io.WriteString(w, `{"images":[{"url":"https://example.com/dog.png"}],"seed":7}`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	resp, err := callFALAPI(testFALClient(t, srv), "test-key", ModelInfo{}, "fal-ai/test-model", ImageRequest{Prompt: "a dog"})
+	if err != nil {
+		t.Fatalf("callFALAPI: %v", err)
+	}
+	if polls != 1 {
+		t.Errorf("status polled %d times, want 1", polls)
+	}
+	if len(resp.Images) != 1 || resp.Images[0].URL != "https://example.com/dog.png" || resp.Seed != 7 {
+		t.Errorf("response = %+v, want dog.png with seed 7", resp)
+	}
+}
+
+func TestCallFALAPIRetriesServerErrors(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, `{"detail":"busy"}`)
+			return
+		}
+		io.WriteString(w, `{"images":[{"url":"https://example.com/ok.png"}]}`)
+	}))
+	defer srv.Close()
+
+	client := testFALClient(t, srv)
+	client.sync = true
+	client.maxRetries = 2
+	if _, err := callFALAPI(client, "test-key", ModelInfo{}, "fal-ai/test-model", ImageRequest{}); err != nil {
+		t.Fatalf("callFALAPI: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("made %d attempts, want 3", attempts)
+	}
+}
+
+func TestCallFALAPIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-fal-request-id", "req-9")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		io.WriteString(w, `{"detail":"prompt is required"}`)
+	}))
+	defer srv.Close()
+
+	client := testFALClient(t, srv)
+	client.sync = true
+	client.maxRetries = 3
+	_, err := callFALAPI(client, "test-key", ModelInfo{}, "fal-ai/test-model", ImageRequest{})
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("err = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusUnprocessableEntity || apiErr.RequestID != "req-9" {
+		t.Errorf("got status %d, request id %q; want 422, req-9", apiErr.StatusCode, apiErr.RequestID)
+	}
+	if !strings.Contains(apiErr.Message, "prompt is required") {
+		t.Errorf("message = %q, want the detail", apiErr.Message)
+	}
+}
+
+func TestRetryDelayLargeAttempt(t *testing.T) {
+	for _, attempt := range []int{0, 10, 34, 63, 100} {
+		if d := retryDelay(attempt, 0, 30*time.Second); d <= 0 || d > 30*time.Second {
+			t.Errorf("retryDelay(%d) = %s, want (0, 30s]", attempt, d)
+		}
+	}
+}
+
+func TestCallFALAPIPayloadTooLargeCountsImages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}))
+	defer srv.Close()
+
+	client := testFALClient(t, srv)
+	client.sync = true
+	req := ImageRequest{ImageURL: "data:image/png;base64,AAAA", MaskURL: "data:image/png;base64,AAAA"}
+	_, err := callFALAPI(client, "test-key", ModelInfo{}, "fal-ai/test-model", req)
+	if err == nil || !strings.Contains(err.Error(), "with 2 input image(s)") {
+		t.Errorf("err = %v, want it to count the image and the mask", err)
+	}
+}
+
+func TestDownloadImageSniffsOctetStream(t *testing.T) {
+	var img bytes.Buffer
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, 1, 1)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			io.WriteString(w, "<html>expired</html>")
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(img.Bytes())
+	}))
+	defer srv.Close()
+	progressEnabled = false
+
+	dest := filepath.Join(t.TempDir(), "out.png")
+	if err := downloadImage(srv.URL+"/image", dest); err != nil {
+		t.Fatalf("downloadImage: %v", err)
+	}
+	if err := downloadImage(srv.URL+"/page", dest); !errors.Is(err, errNotImage) {
+		t.Errorf("err = %v, want errNotImage for an HTML body", err)
+	}
+}
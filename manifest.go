@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest is a YAML file describing a set of generation jobs:
+//
+//	vars:
+//	  subject: a red fox
+//	defaults:
+//	  model: flux2-pro
+//	  size: "16:9"
+//	jobs:
+//	  - prompt: "{{subject}} in the snow"
+//	  - prompt: "{{subject}} wearing @image1's scarf"
+//	    model: nano-banana-pro
+//	    images: [scarf.png]
+type Manifest struct {
+	Vars     map[string]string `yaml:"vars"`
+	Defaults ManifestJob       `yaml:"defaults"`
+	Jobs     []ManifestJob     `yaml:"jobs"`
+}
+
+// ManifestJob is a single generation within a manifest. Empty fields fall
+// back to the manifest defaults.
+type ManifestJob struct {
+	Prompt string            `yaml:"prompt"`
+	Model  string            `yaml:"model"`
+	Size   string            `yaml:"size"`
+	Format string            `yaml:"format"`
+	Images []string          `yaml:"images"` // Relative to the manifest file, or http(s) URLs
+	Output string            `yaml:"output"`
+	Seed   *int              `yaml:"seed"`
+	Vars   map[string]string `yaml:"vars"`
+}
+
+// templateVarPattern matches {{name}} placeholders in manifest prompts
+var templateVarPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_-]+)\s*\}\}`)
+
+func loadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// withDefaults returns the job with empty fields filled from the manifest defaults
+func (m *Manifest) withDefaults(job ManifestJob) ManifestJob {
+	if job.Model == "" {
+		job.Model = m.Defaults.Model
+	}
+	if job.Model == "" {
+		job.Model = "z-turbo"
+	}
+	if job.Size == "" {
+		job.Size = m.Defaults.Size
+	}
+	if job.Format == "" {
+		job.Format = m.Defaults.Format
+	}
+	if len(job.Images) == 0 {
+		job.Images = m.Defaults.Images
+	}
+	if job.Seed == nil {
+		job.Seed = m.Defaults.Seed
+	}
+	return job
+}
+
+// expandPrompt substitutes {{var}} placeholders, job vars taking precedence
+// over manifest vars. It returns the names of any unresolved placeholders.
+func (m *Manifest) expandPrompt(job ManifestJob) (string, []string) {
+	var missing []string
+	prompt := templateVarPattern.ReplaceAllStringFunc(job.Prompt, func(match string) string {
+		name := templateVarPattern.FindStringSubmatch(match)[1]
+		if v, ok := job.Vars[name]; ok {
+			return v
+		}
+		if v, ok := m.Vars[name]; ok {
+			return v
+		}
+		missing = append(missing, name)
+		return match
+	})
+	return prompt, missing
+}
+
+// validate checks every job without calling the API and returns a list of
+// problems. baseDir is used to resolve relative image paths.
+func (m *Manifest) validate(baseDir string) []string {
+	var problems []string
+	if len(m.Jobs) == 0 {
+		problems = append(problems, "manifest has no jobs")
+	}
+
+	for i, raw := range m.Jobs {
+		job := m.withDefaults(raw)
+		addProblem := func(format string, args ...any) {
+			problems = append(problems, fmt.Sprintf("job %d: %s", i+1, fmt.Sprintf(format, args...)))
+		}
+
+		if job.Prompt == "" {
+			addProblem("prompt is empty")
+		}
+		if _, missing := m.expandPrompt(job); len(missing) > 0 {
+			sort.Strings(missing)
+			for _, name := range missing {
+				addProblem("template variable '{{%s}}' is not defined", name)
+			}
+		}
+
+		info, ok := models[resolveModel(job.Model)]
+		if !ok {
+			addProblem("unknown model '%s'", job.Model)
+			continue
+		}
+
+		isEdit := len(job.Images) > 0
+		if isEdit && !info.canEdit() {
+			addProblem("model '%s' does not support editing", job.Model)
+		}
+		if job.Size != "" {
+			if err := validateSize(info, job.Size, isEdit); err != nil {
+				addProblem("%v", err)
+			}
+		}
+		if _, err := checkImageRefs(job.Prompt, len(job.Images)); err != nil {
+			addProblem("%v", err)
+		}
+		if job.Format != "" {
+			if _, err := normalizeFormat(job.Format); err != nil {
+				addProblem("%v", err)
+			}
+		}
+
+		var readable []string
+		for _, img := range job.Images {
+			if isURL(img) {
+				readable = append(readable, img) // Fetched by FAL at generation time
+				continue
+			}
+			imgPath := img
+			if !filepath.IsAbs(imgPath) {
+				imgPath = filepath.Join(baseDir, imgPath)
+			}
+			if _, _, err := getImageDimensions(imgPath); err != nil {
+				addProblem("input image %s is not readable: %v", img, err)
+				continue
+			}
+			readable = append(readable, imgPath)
+		}
+		if isEdit {
+			if err := checkInputLimits(info, resolveModel(job.Model), readable, 1); err != nil {
+				addProblem("%v", err)
+			}
+		}
+	}
+	return problems
+}
+
+func runValidate(cmd *cobra.Command, args []string) {
+	path := args[0]
+	m, err := loadManifest(path)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	problems := m.validate(filepath.Dir(path))
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: %d problem(s) found:\n", path, len(problems))
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "  - %s\n", p)
+		}
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s: %d job(s) OK\n", path, len(m.Jobs))
+}
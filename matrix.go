@@ -0,0 +1,301 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// matrixKeys are the settings --matrix can vary
+var matrixKeys = []string{"model", "size", "seed", "steps", "guidance"}
+
+// matrixAxis is one --matrix setting and the values it takes
+type matrixAxis struct {
+	key    string
+	values []string
+}
+
+// parseMatrix parses --matrix flags into axes, in the order given
+func parseMatrix(flags []string) ([]matrixAxis, error) {
+	var axes []matrixAxis
+	for _, flag := range flags {
+		key, list, ok := strings.Cut(flag, "=")
+		key = strings.TrimSpace(key)
+		values := splitList(list)
+		if !ok || len(values) == 0 {
+			return nil, fmt.Errorf("invalid --matrix '%s' (expected key=value1,value2)", flag)
+		}
+		if !slices.Contains(matrixKeys, key) {
+			return nil, fmt.Errorf("unknown --matrix key '%s' (valid: %s)", key, strings.Join(matrixKeys, ", "))
+		}
+		if slices.ContainsFunc(axes, func(a matrixAxis) bool { return a.key == key }) {
+			return nil, fmt.Errorf("--matrix %s is given twice; list all its values in one flag", key)
+		}
+		for _, v := range values {
+			var err error
+			switch key {
+			case "seed":
+				var n int
+				if n, err = strconv.Atoi(v); err == nil && n < 0 {
+					err = errors.New("negative")
+				}
+			case "steps":
+				var n int
+				if n, err = strconv.Atoi(v); err == nil && n <= 0 {
+					err = errors.New("not positive")
+				}
+			case "guidance":
+				var f float64
+				if f, err = strconv.ParseFloat(v, 64); err == nil && f <= 0 {
+					err = errors.New("not positive")
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid --matrix %s value '%s'", key, v)
+			}
+		}
+		axes = append(axes, matrixAxis{key: key, values: values})
+	}
+	return axes, nil
+}
+
+// matrixCombos returns every combination of the axes' values, with the
+// first axis changing slowest
+func matrixCombos(axes []matrixAxis) []map[string]string {
+	combos := []map[string]string{{}}
+	for _, axis := range axes {
+		var next []map[string]string
+		for _, combo := range combos {
+			for _, v := range axis.values {
+				c := maps.Clone(combo)
+				c[axis.key] = v
+				next = append(next, c)
+			}
+		}
+		combos = next
+	}
+	return combos
+}
+
+// matrixSuffix describes a combination for its file name, e.g.
+// "model-flux2-pro_size-16x9"
+func matrixSuffix(axes []matrixAxis, combo map[string]string) string {
+	var parts []string
+	for _, axis := range axes {
+		parts = append(parts, axis.key+"-"+slugify(sizeSuffix(combo[axis.key])))
+	}
+	return strings.Join(parts, "_")
+}
+
+// matrixCaption describes a combination on a contact sheet by the values
+// it varies, plus the shared seed
+func matrixCaption(opts *genOptions, axes []matrixAxis, combo map[string]string) string {
+	var values []string
+	for _, axis := range axes {
+		values = append(values, combo[axis.key])
+	}
+	caption := strings.Join(values, "  ")
+	if _, ok := combo["seed"]; !ok {
+		caption += fmt.Sprintf("  seed %d", opts.seed)
+	}
+	return caption
+}
+
+// checkMatrix rejects --matrix combinations that don't fit running each
+// combination as its own job, before anything is paid for
+func checkMatrix(opts *genOptions) error {
+	switch {
+	case opts.interactive || opts.watch || opts.thenPrompt != "" || opts.seedSweep > 0:
+		return errors.New("--matrix cannot be combined with --interactive, --watch, --then, or --seed-sweep")
+	case jsonOutput || opts.urlOnly || opts.rawOutput:
+		return errors.New("--matrix cannot be combined with --json, --url-only, or --raw")
+	case opts.maskPath != "" || opts.targetSimilarity != "":
+		return errors.New("--matrix cannot be combined with --mask or --target-similarity")
+	case opts.numImages > 1:
+		return errors.New("--matrix cannot be combined with --num")
+	case len(splitList(opts.size)) > 1:
+		return errors.New("--matrix takes a single --size (vary it with --matrix size=...)")
+	case slices.ContainsFunc(opts.inputImages, isInputPattern):
+		return errors.New("--matrix cannot be combined with a glob or directory -i")
+	}
+	return nil
+}
+
+// runMatrix runs the prompt once per --matrix combination, on up to
+// --concurrency at once. Settings the matrix doesn't vary, including the
+// seed, are shared so the results can be compared side by side.
+func runMatrix(opts *genOptions, apiKey, prompt string) {
+	if opts.batchConcurrency < 1 {
+		fatalf("--concurrency must be at least 1")
+	}
+	axes, err := parseMatrix(opts.matrixFlags)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	combos := matrixCombos(axes)
+	isEditMode := len(opts.inputImages) > 0
+
+	// Catch unknown models and sizes they can't take before anything is sent
+	counts := make(map[string]int)
+	ignored := make(map[string]bool)
+	opts.requestTimeout = defaultTimeout
+	for _, combo := range combos {
+		name := cmp.Or(combo["model"], opts.model)
+		resolved := resolveModel(name)
+		info, ok := models[resolved]
+		if !ok {
+			fatalf("unknown model '%s'. Use 'gen models' to see available options.", name)
+		}
+		if _, err := modelPathFor(opts, info, name, isEditMode); err != nil {
+			fatalf("%v", err)
+		}
+		if sz := cmp.Or(combo["size"], opts.size); sz != "" {
+			if err := validateSize(info, sz, isEditMode); err != nil {
+				fatalf("model '%s': %v", name, err)
+			}
+		}
+		for _, key := range []string{"steps", "guidance"} {
+			if _, ok := combo[key]; ok && !info.SupportsSteps && !ignored[name+key] {
+				ignored[name+key] = true
+				if err := ignoredFlag("model '%s' does not support --matrix %s", name, key); err != nil {
+					fatalf("%v", err)
+				}
+			}
+		}
+		// Requests share a timeout, so allow for the slowest model
+		if !opts.timeoutSet && info.DefaultTimeout > opts.requestTimeout {
+			opts.requestTimeout = info.DefaultTimeout
+		}
+		counts[resolved]++
+	}
+	if opts.timeoutSet {
+		opts.requestTimeout = opts.timeout
+	}
+
+	outDir, err := jobOutputDir(opts, "--matrix")
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	if opts.translate {
+		translated, err := translatePrompt(opts, apiKey, prompt)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if translated != prompt {
+			infof("Original prompt: %s\n", prompt)
+			infof("Translated prompt: %s\n", translated)
+			prompt = translated
+		}
+	}
+	if opts.seed < 0 && !opts.randomSeed {
+		opts.seed = rand.IntN(math.MaxInt32)
+	}
+
+	var imageURLs []string
+	for i, imgPath := range opts.inputImages {
+		imageURL, err := inputImageURL(opts, imgPath)
+		if err != nil {
+			fatalf("failed to read image %d (%s): %v", i+1, imgPath, err)
+		}
+		imageURLs = append(imageURLs, imageURL)
+	}
+
+	dests := make([]string, len(combos))
+	for i, combo := range combos {
+		name := slugify(prompt)
+		if opts.nameTemplate != "" {
+			meta := ImageMetadata{Prompt: prompt, Model: resolveModel(cmp.Or(combo["model"], opts.model)), Seed: opts.seed, Format: opts.format, CreatedAt: time.Now()}
+			if s, err := strconv.Atoi(combo["seed"]); err == nil {
+				meta.Seed = s
+			}
+			name = expandNameTemplate(opts.nameTemplate, meta, i+1)
+		}
+		dests[i] = filepath.Join(outDir, name+"_"+matrixSuffix(axes, combo)+"."+opts.format)
+	}
+
+	if err := confirmCosts(opts, counts); err != nil {
+		fatalf("%v", err)
+	}
+	if opts.dryRun {
+		fmt.Printf("Would run %d combinations:\n", len(combos))
+		for i := range combos {
+			fmt.Printf("  %s\n", dests[i])
+		}
+		return
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		fatalf("failed to create output directory: %v", err)
+	}
+
+	runJobs(opts, len(combos), func(i int) string {
+		return matrixSuffix(axes, combos[i])
+	}, func(i int) (jobResult, error) {
+		result, err := runMatrixJob(opts, apiKey, prompt, combos[i], imageURLs, dests[i])
+		result.caption = matrixCaption(opts, axes, combos[i])
+		return result, err
+	})
+}
+
+// runMatrixJob generates one --matrix combination and saves it to dest
+func runMatrixJob(opts *genOptions, apiKey, prompt string, combo map[string]string, imageURLs []string, dest string) (jobResult, error) {
+	name := cmp.Or(combo["model"], opts.model)
+	resolvedModel := resolveModel(name)
+	info := models[resolvedModel]
+	isEditMode := len(imageURLs) > 0
+	modelPath, err := modelPathFor(opts, info, name, isEditMode)
+	if err != nil {
+		return jobResult{}, err
+	}
+	if isEditMode {
+		if err := checkInputLimits(info, resolvedModel, opts.inputImages, 1); err != nil {
+			return jobResult{}, err
+		}
+	}
+
+	sizeValue := resolveSizeValue(opts, info, cmp.Or(combo["size"], opts.size), isEditMode)
+	req, err := buildRequest(opts, info, name, prompt, sizeValue, imageURLs)
+	if err != nil {
+		return jobResult{}, err
+	}
+	if v, ok := combo["seed"]; ok {
+		s, _ := strconv.Atoi(v)
+		req.Seed = &s
+	}
+	// Models without steps were warned about up front
+	if info.SupportsSteps {
+		if v, ok := combo["steps"]; ok {
+			req.NumInferenceSteps, _ = strconv.Atoi(v)
+		}
+		if v, ok := combo["guidance"]; ok {
+			req.GuidanceScale, _ = strconv.ParseFloat(v, 64)
+		}
+	}
+
+	meta := ImageMetadata{
+		Prompt:         prompt,
+		NegativePrompt: req.NegativePrompt,
+		Model:          resolvedModel,
+		ModelPath:      modelPath,
+		Size:           sizeValue,
+		Images:         absPaths(opts.inputImages),
+		Format:         opts.format,
+		Steps:          req.NumInferenceSteps,
+		Guidance:       req.GuidanceScale,
+		Strength:       req.Strength,
+	}
+	path, err := runJobRequest(opts, apiKey, info, modelPath, req, &meta, dest)
+	if err != nil {
+		return jobResult{}, err
+	}
+	return jobResult{path: path}, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImageMetadata is written as a <name>.json sidecar next to each saved image
+// with --metadata. Its fields mirror the flags needed to regenerate the image.
+type ImageMetadata struct {
+	Prompt         string    `json:"prompt"`
+	NegativePrompt string    `json:"negative_prompt,omitempty"`
+	Model          string    `json:"model"`
+	ModelPath      string    `json:"model_path"`
+	Seed           int       `json:"seed"`
+	Size           string    `json:"size,omitempty"`
+	Images         []string  `json:"images,omitempty"` // Input images for edits, as absolute paths
+	Mask           string    `json:"mask,omitempty"`
+	Format         string    `json:"format"`
+	Steps          int       `json:"steps,omitempty"`
+	Guidance       float64   `json:"guidance,omitempty"`
+	Strength       *float64  `json:"strength,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// sidecarPath returns the metadata file path for an image path
+func sidecarPath(imagePath string) string {
+	return strings.TrimSuffix(imagePath, filepath.Ext(imagePath)) + ".json"
+}
+
+// writeSidecars writes meta next to every local file in destinations,
+// skipping stdout and remote uploads
+func writeSidecars(destinations []string, meta ImageMetadata) {
+	for _, dest := range destinations {
+		if dest == "stdout" || isRemoteDest(dest) {
+			continue
+		}
+		path := sidecarPath(dest)
+		if err := writeJSONFile(path, meta); err != nil {
+			warnf("could not write metadata: %v", err)
+			continue
+		}
+		infof("Metadata saved to: %s\n", path)
+	}
+}
+
+// writeSeedFiles writes seed to a <name>.seed file next to every local file
+// in destinations
+func writeSeedFiles(destinations []string, seed int) {
+	for _, dest := range destinations {
+		if dest == "stdout" || isRemoteDest(dest) {
+			continue
+		}
+		path := strings.TrimSuffix(dest, filepath.Ext(dest)) + ".seed"
+		if err := os.WriteFile(path, []byte(strconv.Itoa(seed)+"\n"), 0644); err != nil {
+			warnf("could not write seed: %v", err)
+		}
+	}
+}
+
+// absPaths makes input image paths absolute so a sidecar works from any
+// directory. URLs are left as they are.
+func absPaths(paths []string) []string {
+	var out []string
+	for _, p := range paths {
+		if isURL(p) {
+			out = append(out, p)
+			continue
+		}
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		out = append(out, p)
+	}
+	return out
+}
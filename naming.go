@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nameTokenPattern matches a {token} in --name-template
+var nameTokenPattern = regexp.MustCompile(`\{([a-z]*)\}`)
+
+// nameTokens are the placeholders --name-template understands
+var nameTokens = []string{"model", "seed", "date", "time", "slug", "n"}
+
+// validateNameTemplate checks a --name-template for unknown tokens and
+// path separators before anything is generated
+func validateNameTemplate(tmpl string) error {
+	if strings.ContainsAny(tmpl, `/\`) {
+		return fmt.Errorf("--name-template '%s' must be a file name, not a path (use -o for the directory)", tmpl)
+	}
+	for _, m := range nameTokenPattern.FindAllStringSubmatch(tmpl, -1) {
+		if !slices.Contains(nameTokens, m[1]) {
+			return fmt.Errorf("unknown token %s in --name-template (valid: {%s})", m[0], strings.Join(nameTokens, "}, {"))
+		}
+	}
+	return nil
+}
+
+// expandNameTemplate fills in a --name-template for an image, returning a
+// file name without extension. n is the image's position in a batch or
+// among -n images.
+func expandNameTemplate(tmpl string, meta ImageMetadata, n int) string {
+	created := meta.CreatedAt
+	if created.IsZero() {
+		created = time.Now()
+	}
+	return nameTokenPattern.ReplaceAllStringFunc(tmpl, func(token string) string {
+		switch token[1 : len(token)-1] {
+		case "model":
+			return meta.Model
+		case "seed":
+			return strconv.Itoa(meta.Seed)
+		case "date":
+			return created.Format("20060102")
+		case "time":
+			return created.Format("150405")
+		case "slug":
+			return slugify(meta.Prompt)
+		case "n":
+			return strconv.Itoa(n)
+		}
+		return token
+	})
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stdoutDest is the output destination that writes image bytes to stdout
+const stdoutDest = "-"
+
+// SavedOutput records where a downloaded image ended up
+type SavedOutput struct {
+	LocalPath    string   // File on disk holding the image (may be temporary)
+	Temporary    bool     // LocalPath is a temp file that should be removed when done
+	Destinations []string // Every destination the image was written to
+}
+
+// isRemoteDest reports whether dest is a cloud storage URL
+func isRemoteDest(dest string) bool {
+	return strings.HasPrefix(dest, "s3://") || strings.HasPrefix(dest, "gs://")
+}
+
+// generatedFileName returns the file name for an auto-named output: name
+// (from --name-template) if given, otherwise a timestamped default
+func generatedFileName(ext, name string) string {
+	if name != "" {
+		return name + "." + ext
+	}
+	return fmt.Sprintf("generated_%d.%s", time.Now().Unix(), ext)
+}
+
+// withSuffix inserts suffix between a path's base name and its extension
+func withSuffix(path, suffix string) string {
+	if suffix == "" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + suffix + ext
+}
+
+// resolveLocalPath turns a local destination into a file path. An empty
+// destination means the default output directory, and an existing directory
+// gets an auto-generated file name inside it, from name if it's set.
+// suffix distinguishes multiple outputs from one run.
+func resolveLocalPath(opts *genOptions, dest, ext, modelName, suffix, name string) (string, error) {
+	autoNamed := true
+	outPath := dest
+	if outPath == "" {
+		dir, err := getDefaultOutputDir(opts)
+		if err != nil {
+			return "", err
+		}
+		outPath = filepath.Join(dir, generatedFileName(ext, name))
+	} else if info, err := os.Stat(outPath); err == nil && info.IsDir() {
+		outPath = filepath.Join(outPath, generatedFileName(ext, name))
+	} else {
+		autoNamed = false
+	}
+	outPath = withSuffix(outPath, suffix)
+
+	// An explicit output file is taken literally; only directory outputs are grouped
+	if opts.subdirByModel && autoNamed && modelName != "" {
+		return withModelSubdir(outPath, modelName)
+	}
+	return outPath, nil
+}
+
+// downloadWithRetry downloads an image, retrying dropped connections and
+// server errors up to --retries times. A URL that has expired or doesn't
+// hold an image fails at once. Data URIs from --inline are written directly.
+func downloadWithRetry(opts *genOptions, url, path string) error {
+	if isDataURI(url) {
+		return saveDataURI(url, path)
+	}
+	for attempt := 0; ; attempt++ {
+		err := downloadImage(url, path)
+		if appCtx.Err() != nil {
+			return errInterrupted
+		}
+		if err == nil || attempt >= opts.maxRetries || errors.Is(err, errNotImage) {
+			return err
+		}
+		wait := retryDelay(attempt, 0, opts.retryMaxWait)
+		infof("%v; retrying download in %.1fs (%d/%d)...\n", err, wait.Seconds(), attempt+1, opts.maxRetries)
+		if err := sleepCtx(wait); err != nil {
+			return err
+		}
+	}
+}
+
+// saveOutputs downloads imageURL once and writes it to every destination.
+// Destinations may be local files or directories, "-" for stdout, or
+// s3:// and gs:// URLs. With no destinations the default output directory is used.
+// name is the file name (without extension) for outputs that are auto-named;
+// empty means the default. A non-nil meta is embedded in the image unless
+// --no-embed is set.
+func saveOutputs(opts *genOptions, imageURL string, dests []string, ext, modelName, suffix, name string, meta *ImageMetadata) (*SavedOutput, error) {
+	if len(dests) == 0 {
+		dests = []string{""}
+	}
+
+	var localDests, otherDests []string
+	for _, dest := range dests {
+		if dest == stdoutDest || isRemoteDest(dest) {
+			otherDests = append(otherDests, dest)
+		} else {
+			localDests = append(localDests, dest)
+		}
+	}
+
+	saved := &SavedOutput{}
+
+	// Download to the first local destination, or a temp file if there is none
+	if len(localDests) > 0 {
+		primary, err := resolveLocalPath(opts, localDests[0], ext, modelName, suffix, name)
+		if err != nil {
+			return nil, err
+		}
+		saved.LocalPath = primary
+		saved.Destinations = append(saved.Destinations, primary)
+		localDests = localDests[1:]
+	} else {
+		tmpDir, err := os.MkdirTemp("", "gen-cli-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp directory: %w", err)
+		}
+		saved.LocalPath = filepath.Join(tmpDir, withSuffix(generatedFileName(ext, name), suffix))
+		saved.Temporary = true
+	}
+
+	if err := downloadWithRetry(opts, imageURL, saved.LocalPath); err != nil {
+		saved.cleanup()
+		return nil, err
+	}
+	localPath, actualExt, err := matchFormat(opts, saved.LocalPath, ext)
+	if err != nil {
+		saved.cleanup()
+		return nil, err
+	}
+	renamed := localPath != saved.LocalPath
+	if renamed {
+		// Renamed to its real format; the copies follow suit
+		if !saved.Temporary {
+			saved.Destinations[0] = localPath
+		}
+		saved.LocalPath, ext = localPath, actualExt
+	}
+
+	// Re-encode before copying so every destination gets the same bytes
+	if err := reencodeOutput(opts, saved.LocalPath); err != nil {
+		warnf("could not re-encode %s: %v", saved.LocalPath, err)
+	}
+	if opts.maxFileSizeBytes > 0 {
+		adjustment, err := fitFileSize(opts, saved.LocalPath, opts.maxFileSizeBytes)
+		if err != nil {
+			warnf("%v", err)
+		} else if adjustment != "" {
+			infof("Reduced file size: %s\n", adjustment)
+		}
+	}
+	if meta != nil && !opts.noEmbed {
+		if err := embedMetadata(saved.LocalPath, *meta); err != nil {
+			warnf("could not embed metadata: %v", err)
+		}
+	}
+
+	for _, dest := range localDests {
+		outPath, err := resolveLocalPath(opts, dest, ext, modelName, suffix, name)
+		if err != nil {
+			return saved, err
+		}
+		if renamed {
+			outPath = strings.TrimSuffix(outPath, filepath.Ext(outPath)) + "." + ext
+		}
+		if err := copyFile(saved.LocalPath, outPath); err != nil {
+			return saved, fmt.Errorf("failed to write %s: %w", outPath, err)
+		}
+		saved.Destinations = append(saved.Destinations, outPath)
+	}
+
+	if len(opts.alsoFormats) > 0 {
+		converted, err := saveAlsoFormats(opts, saved.LocalPath, saved.Destinations, opts.alsoFormats)
+		saved.Destinations = append(saved.Destinations, converted...)
+		if err != nil {
+			return saved, err
+		}
+	}
+
+	for _, dest := range otherDests {
+		if dest == stdoutDest {
+			if err := writeFileTo(os.Stdout, saved.LocalPath); err != nil {
+				return saved, fmt.Errorf("failed to write to stdout: %w", err)
+			}
+			saved.Destinations = append(saved.Destinations, "stdout")
+			continue
+		}
+
+		remote := dest
+		if strings.HasSuffix(remote, "/") {
+			remote += filepath.Base(saved.LocalPath)
+		} else {
+			remote = withSuffix(remote, suffix)
+		}
+		if err := uploadRemote(saved.LocalPath, remote); err != nil {
+			return saved, err
+		}
+		saved.Destinations = append(saved.Destinations, remote)
+	}
+
+	return saved, nil
+}
+
+// cleanup removes the temporary download, if one was used
+func (s *SavedOutput) cleanup() {
+	if s != nil && s.Temporary {
+		_ = os.RemoveAll(filepath.Dir(s.LocalPath))
+	}
+}
+
+func copyFile(src, dst string) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := writeFileTo(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func writeFileTo(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// uploadRemote copies a local file to cloud storage using the provider's CLI,
+// so credentials and configuration come from the user's existing setup
+func uploadRemote(localPath, dest string) error {
+	var args []string
+	switch {
+	case strings.HasPrefix(dest, "s3://"):
+		args = []string{"aws", "s3", "cp", "--only-show-errors", localPath, dest}
+	case strings.HasPrefix(dest, "gs://"):
+		if _, err := exec.LookPath("gcloud"); err == nil {
+			args = []string{"gcloud", "storage", "cp", localPath, dest}
+		} else {
+			args = []string{"gsutil", "-q", "cp", localPath, dest}
+		}
+	default:
+		return fmt.Errorf("unsupported destination '%s'", dest)
+	}
+
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return fmt.Errorf("uploading to %s requires the '%s' CLI", dest, args[0])
+	}
+
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("upload to %s failed: %v: %s", dest, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Terminal renderers, from richest to most portable
+const (
+	rendererKitty     = "kitty"
+	rendererITerm     = "iterm"
+	rendererTrueColor = "truecolor"
+	rendererANSI256   = "ansi256"
+	rendererASCII     = "ascii"
+)
+
+// Maximum preview width in terminal columns
+const maxPreviewCols = 80
+
+// asciiRamp maps brightness (dark to light) to characters
+const asciiRamp = " .:-=+*#%@"
+
+// detectRenderer picks the best preview renderer based on what the terminal advertises
+func detectRenderer() string {
+	term := os.Getenv("TERM")
+	termProgram := os.Getenv("TERM_PROGRAM")
+
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || termProgram == "ghostty":
+		return rendererKitty
+	case termProgram == "iTerm.app" || termProgram == "WezTerm":
+		return rendererITerm
+	}
+
+	colorTerm := strings.ToLower(os.Getenv("COLORTERM"))
+	switch {
+	case colorTerm == "truecolor" || colorTerm == "24bit":
+		return rendererTrueColor
+	case term == "" || term == "dumb":
+		return rendererASCII
+	default:
+		return rendererANSI256
+	}
+}
+
+// previewColumns returns how many columns the preview may use
+func previewColumns() int {
+	cols := maxPreviewCols
+	if c, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && c > 0 && c < cols {
+		cols = c
+	}
+	return cols
+}
+
+// renderPreview prints a downscaled preview of the image at path to stdout
+func renderPreview(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	cols := previewColumns()
+	switch detectRenderer() {
+	case rendererKitty:
+		return renderKitty(os.Stdout, img, cols)
+	case rendererITerm:
+		return renderITerm(os.Stdout, img, cols)
+	case rendererTrueColor:
+		renderHalfBlocks(os.Stdout, img, cols, true)
+	case rendererANSI256:
+		renderHalfBlocks(os.Stdout, img, cols, false)
+	default:
+		renderASCII(os.Stdout, img, cols)
+	}
+	return nil
+}
+
+// scaleImage resizes img to the given width, preserving aspect ratio.
+// cellAspect compensates for non-square output cells (height/width of one cell).
+func scaleImage(img image.Image, width int, cellAspect float64) *image.RGBA {
+	b := img.Bounds()
+	if width > b.Dx() {
+		width = b.Dx()
+	}
+	height := int(float64(width) * float64(b.Dy()) / float64(b.Dx()) / cellAspect)
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// encodePreviewPNG downscales img and encodes it as PNG for inline image protocols
+func encodePreviewPNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleImage(img, 1024, 1)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderKitty writes the image using the kitty graphics protocol
+func renderKitty(w io.Writer, img image.Image, cols int) error {
+	data, err := encodePreviewPNG(img)
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	// Payloads must be sent in chunks of at most 4096 bytes
+	const chunkSize = 4096
+	for i := 0; i < len(encoded); i += chunkSize {
+		end := min(i+chunkSize, len(encoded))
+		more := 0
+		if end < len(encoded) {
+			more = 1
+		}
+		if i == 0 {
+			fmt.Fprintf(w, "\x1b_Ga=T,f=100,c=%d,m=%d;%s\x1b\\", cols, more, encoded[i:end])
+		} else {
+			fmt.Fprintf(w, "\x1b_Gm=%d;%s\x1b\\", more, encoded[i:end])
+		}
+	}
+	fmt.Fprintln(w)
+	return nil
+}
+
+// renderITerm writes the image using the iTerm2 inline image protocol
+func renderITerm(w io.Writer, img image.Image, cols int) error {
+	data, err := encodePreviewPNG(img)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\x1b]1337;File=inline=1;size=%d;width=%d;preserveAspectRatio=1:%s\a\n",
+		len(data), cols, base64.StdEncoding.EncodeToString(data))
+	return nil
+}
+
+// renderHalfBlocks draws two pixels per cell using the upper half block
+// character, with the top pixel as foreground and the bottom as background
+func renderHalfBlocks(w io.Writer, img image.Image, cols int, trueColor bool) {
+	// Half blocks make each cell two pixels tall, so pixels come out roughly square
+	scaled := scaleImage(img, cols, 1)
+	b := scaled.Bounds()
+
+	var sb strings.Builder
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			top := scaled.RGBAAt(x, y)
+			bottom := top
+			if y+1 < b.Max.Y {
+				bottom = scaled.RGBAAt(x, y+1)
+			}
+			if trueColor {
+				fmt.Fprintf(&sb, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀",
+					top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+			} else {
+				fmt.Fprintf(&sb, "\x1b[38;5;%dm\x1b[48;5;%dm▀",
+					ansi256(top.R, top.G, top.B), ansi256(bottom.R, bottom.G, bottom.B))
+			}
+		}
+		sb.WriteString("\x1b[0m\n")
+	}
+	io.WriteString(w, sb.String())
+}
+
+// ansi256 maps an RGB color to the nearest entry in the xterm 6x6x6 color cube
+func ansi256(r, g, b uint8) int {
+	level := func(c uint8) int {
+		return (int(c)*5 + 127) / 255
+	}
+	return 16 + 36*level(r) + 6*level(g) + level(b)
+}
+
+// renderASCII draws the image as brightness-mapped characters for plain terminals
+func renderASCII(w io.Writer, img image.Image, cols int) {
+	// Terminal cells are roughly twice as tall as they are wide
+	scaled := scaleImage(img, cols, 2)
+	b := scaled.Bounds()
+
+	var sb strings.Builder
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := scaled.RGBAAt(x, y)
+			lum := (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+			sb.WriteByte(asciiRamp[lum*(len(asciiRamp)-1)/255])
+		}
+		sb.WriteByte('\n')
+	}
+	io.WriteString(w, sb.String())
+}
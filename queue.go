@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	queuePollInterval = time.Second
+	queuePollTimeout  = 30 * time.Second // Per status/result request
+)
+
+// Queue request states reported by the status endpoint
+const (
+	queueStatusInQueue    = "IN_QUEUE"
+	queueStatusInProgress = "IN_PROGRESS"
+	queueStatusCompleted  = "COMPLETED"
+)
+
+// queueSubmission is returned when a request is accepted by the queue
+type queueSubmission struct {
+	RequestID   string `json:"request_id"`
+	StatusURL   string `json:"status_url"`
+	ResponseURL string `json:"response_url"`
+	CancelURL   string `json:"cancel_url"`
+}
+
+// queueStatus is returned by the status endpoint while polling
+type queueStatus struct {
+	Status        string `json:"status"`
+	QueuePosition *int   `json:"queue_position"`
+	Logs          []struct {
+		Message string `json:"message"`
+	} `json:"logs"`
+}
+
+// label describes the status for the spinner
+func (s queueStatus) label() string {
+	switch s.Status {
+	case queueStatusInQueue:
+		if s.QueuePosition != nil {
+			return fmt.Sprintf("In queue (position %d)", *s.QueuePosition)
+		}
+		return "In queue"
+	case queueStatusInProgress:
+		if n := len(s.Logs); n > 0 && s.Logs[n-1].Message != "" {
+			return "Generating: " + s.Logs[n-1].Message
+		}
+		return "Generating..."
+	default:
+		return s.Status
+	}
+}
+
+// queueFAL submits a request to the FAL queue, polls its status until it
+// completes, and returns the raw result body. The client's timeout bounds
+// the whole wait; 0 waits indefinitely.
+func queueFAL(client *falClient, apiKey, submitURL string, jsonData []byte) ([]byte, error) {
+	var cancelURL string
+	body, err := withProgress(func() ([]byte, error) {
+		setProgressStatus("Submitting...")
+		body, err := doFAL(client, apiKey, "POST", submitURL, jsonData, client.timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		var sub queueSubmission
+		if err := json.Unmarshal(body, &sub); err != nil {
+			return nil, fmt.Errorf("failed to parse queue response: %w", err)
+		}
+		if sub.RequestID == "" || sub.StatusURL == "" || sub.ResponseURL == "" {
+			return nil, errors.New("queue response is missing the request id or status URLs")
+		}
+
+		cancelURL = sub.CancelURL
+
+		var deadline time.Time
+		if client.timeout > 0 {
+			deadline = time.Now().Add(client.timeout)
+		}
+
+		for {
+			body, err := doFAL(client, apiKey, "GET", sub.StatusURL+"?logs=1", nil, queuePollTimeout)
+			if err != nil {
+				return nil, withRequestID(err, sub.RequestID)
+			}
+
+			var status queueStatus
+			if err := json.Unmarshal(body, &status); err != nil {
+				return nil, fmt.Errorf("failed to parse queue status: %w", err)
+			}
+			if status.Status == queueStatusCompleted {
+				break
+			}
+			setProgressStatus(status.label())
+
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				return nil, fmt.Errorf("timed out after %s waiting for request %s (use --timeout to allow longer, 0 for no limit)", client.timeout, sub.RequestID)
+			}
+			if err := sleepCtx(queuePollInterval); err != nil {
+				return nil, err
+			}
+		}
+
+		setProgressStatus("Fetching result...")
+		body, err = doFAL(client, apiKey, "GET", sub.ResponseURL, nil, queuePollTimeout)
+		if err != nil {
+			return nil, withRequestID(err, sub.RequestID)
+		}
+		return body, nil
+	})
+
+	// On Ctrl-C, ask FAL to drop the request so it isn't run (and billed)
+	// for nothing
+	if appCtx.Err() != nil && cancelURL != "" {
+		cancelQueueRequest(client, apiKey, cancelURL)
+	}
+	return body, err
+}
+
+// cancelQueueRequest asks FAL to cancel a queued request. It runs after
+// Ctrl-C, so it can't use appCtx; failures only matter in verbose logs.
+func cancelQueueRequest(client *falClient, apiKey, cancelURL string) {
+	_, err := doFALContext(context.Background(), client, apiKey, "PUT", cancelURL, nil, 5*time.Second)
+	if err != nil {
+		debugf("Could not cancel the queued request: %v\n", err)
+		return
+	}
+	infof("Cancelled the queued request\n")
+}
+
+// withRequestID tags an API error with the queue request id if it lacks one
+func withRequestID(err error, requestID string) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RequestID == "" {
+		apiErr.RequestID = requestID
+	}
+	return err
+}
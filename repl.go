@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const replHelp = `Enter a prompt to generate it, or a command:
+  /model <name>     switch model (see 'gen models')
+  /size <size>      set the size, e.g. 16:9 or 16:9,1:1
+  /format <format>  set the output format (png, jpeg, webp)
+  /seed <n|random>  fix the seed, or pick a new one each time
+  /image <path>     add an input image for editing
+  /last [prompt]    edit the previous result from now on, optionally running prompt
+  /clear            drop the input images
+  /settings         show the current settings
+  /quit             exit (or Ctrl-D)`
+
+// stdin is shared by the REPL and confirmation prompts so neither loses
+// input the other has buffered
+var stdin = bufio.NewReader(os.Stdin)
+
+// readLine reads a line from stdin without its line ending; ok is false at EOF
+func readLine() (line string, ok bool) {
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", false
+	}
+	return strings.TrimRight(line, "\r\n"), true
+}
+
+// runREPL reads prompts from stdin and generates each with the current
+// settings, which slash commands change between turns. Errors are reported
+// without ending the session.
+func runREPL(opts *genOptions, apiKey string) {
+	if slices.Contains(opts.outputs, stdoutDest) {
+		fatalf("--interactive cannot write images to stdout")
+	}
+
+	seedFixed := opts.seed >= 0
+	var last string // Most recent result, as a local path or URL
+
+	run := func(prompt string) {
+		if !seedFixed {
+			opts.seed = -1
+		}
+		results, err := generate(opts, apiKey, prompt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return
+		}
+		if jsonOutput && len(results) > 0 {
+			printJSONResult(results)
+		}
+		if n := len(results); n > 0 && len(results[n-1].Images) > 0 {
+			last = lastResultInput(results[n-1].Images[0])
+		}
+	}
+
+	fmt.Fprintln(msgOut, "Interactive mode. Type /help for commands.")
+	for {
+		fmt.Fprint(msgOut, "\ngen> ")
+		line, ok := readLine()
+		if !ok {
+			fmt.Fprintln(msgOut)
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "/") {
+			run(line)
+			continue
+		}
+
+		command, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		switch command {
+		case "/quit", "/exit":
+			return
+		case "/help":
+			fmt.Fprintln(msgOut, replHelp)
+		case "/model":
+			if _, ok := models[resolveModel(arg)]; !ok {
+				fmt.Fprintf(os.Stderr, "Error: unknown model '%s'. Use 'gen models' to see available options.\n", arg)
+				continue
+			}
+			opts.model = arg
+		case "/size":
+			opts.size = arg
+		case "/format":
+			f, err := normalizeFormat(arg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				continue
+			}
+			opts.format = f
+		case "/seed":
+			if arg == "random" {
+				seedFixed = false
+				continue
+			}
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 {
+				fmt.Fprintln(os.Stderr, "Error: /seed takes a non-negative number or 'random'")
+				continue
+			}
+			opts.seed, seedFixed = n, true
+		case "/image":
+			if arg == "" {
+				fmt.Fprintln(os.Stderr, "Error: /image needs a path or URL")
+				continue
+			}
+			opts.inputImages = append(opts.inputImages, arg)
+		case "/clear":
+			opts.inputImages = nil
+		case "/last":
+			if last == "" {
+				fmt.Fprintln(os.Stderr, "Error: nothing has been generated yet")
+				continue
+			}
+			opts.inputImages = []string{last}
+			if arg != "" {
+				run(arg)
+			}
+		case "/settings":
+			printREPLSettings(opts, seedFixed)
+		default:
+			fmt.Fprintf(os.Stderr, "Error: unknown command '%s' (try /help)\n", command)
+		}
+	}
+}
+
+// lastResultInput picks the saved file for /last, falling back to the FAL
+// URL when the image was only uploaded or streamed
+func lastResultInput(img GeneratedImage) string {
+	for _, dest := range img.Destinations {
+		if dest != "stdout" && !isRemoteDest(dest) {
+			return dest
+		}
+	}
+	return img.URL
+}
+
+func printREPLSettings(opts *genOptions, seedFixed bool) {
+	seedDesc := "random"
+	if seedFixed {
+		seedDesc = strconv.Itoa(opts.seed)
+	}
+	sizeDesc := opts.size
+	if sizeDesc == "" {
+		sizeDesc = "default"
+	}
+	fmt.Fprintf(msgOut, "Model:  %s\n", opts.model)
+	fmt.Fprintf(msgOut, "Size:   %s\n", sizeDesc)
+	fmt.Fprintf(msgOut, "Format: %s\n", opts.format)
+	fmt.Fprintf(msgOut, "Seed:   %s\n", seedDesc)
+	if len(opts.inputImages) > 0 {
+		fmt.Fprintf(msgOut, "Images: %s\n", strings.Join(opts.inputImages, ", "))
+	}
+}
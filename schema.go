@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaOverrides covers fields whose Go type can't express the wire format
+var schemaOverrides = map[string]map[string]any{
+	"ImageRequest.ImageSize": {
+		"oneOf": []any{
+			map[string]any{"type": "string", "description": "preset name or \"auto\""},
+			map[string]any{"$ref": "#/$defs/ImageSize"},
+		},
+	},
+}
+
+// schemaRoots are the types exposed by the schema subcommand
+var schemaRoots = map[string]reflect.Type{
+	"request":  reflect.TypeOf(ImageRequest{}),
+	"response": reflect.TypeOf(ImageResponse{}),
+}
+
+// schemaBuilder derives JSON schemas from Go types, collecting named structs into $defs
+type schemaBuilder struct {
+	defs map[string]any
+}
+
+func (b *schemaBuilder) schemaFor(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schemaFor(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if _, ok := b.defs[t.Name()]; !ok {
+			b.defs[t.Name()] = nil // Reserve the name to stop recursion
+			b.defs[t.Name()] = b.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	default:
+		return map[string]any{}
+	}
+}
+
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		if override, ok := schemaOverrides[t.Name()+"."+field.Name]; ok {
+			properties[name] = override
+		} else {
+			properties[name] = b.schemaFor(field.Type)
+		}
+
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func runSchema(cmd *cobra.Command, args []string) {
+	b := &schemaBuilder{defs: map[string]any{}}
+	// ImageSize is only reachable through an override, so register it explicitly
+	b.schemaFor(reflect.TypeOf(ImageSize{}))
+
+	doc := map[string]any{"$schema": jsonSchemaDialect}
+	if len(args) == 0 {
+		for _, t := range schemaRoots {
+			b.schemaFor(t)
+		}
+	} else {
+		t, ok := schemaRoots[args[0]]
+		if !ok {
+			fatalf("unknown schema '%s' (valid: request, response)", args[0])
+		}
+		doc["$ref"] = b.schemaFor(t)["$ref"]
+	}
+	doc["$defs"] = b.defs
+
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		fatalf("%v", err)
+	}
+	fmt.Println(string(out))
+}
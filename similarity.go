@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"math/bits"
+	"math/rand/v2"
+	"net/http"
+
+	"golang.org/x/image/draw"
+)
+
+// dHash computes a 64-bit difference hash: the image is shrunk to 9x8
+// grayscale and each bit records whether a pixel is brighter than its
+// right-hand neighbour. Similar-looking images have similar hashes.
+func dHash(img image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// hashSimilarity returns a perceptual similarity between 0 (unrelated) and 1 (identical)
+func hashSimilarity(a, b uint64) float64 {
+	return 1 - float64(bits.OnesCount64(a^b))/64
+}
+
+// fetchImage downloads and decodes an image without saving it
+func fetchImage(url string) (image.Image, error) {
+	if isDataURI(url) {
+		data, err := decodeDataURI(url)
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := image.Decode(bytes.NewReader(data))
+		return img, err
+	}
+	resp, err := httpGet(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed with status %d", resp.StatusCode)
+	}
+	img, _, err := image.Decode(resp.Body)
+	return img, err
+}
+
+// generateWithSimilarity repeats the generation with new seeds until the
+// result's similarity to the reference image falls within [minSim, maxSim],
+// or the attempt cap is hit. The closest result is returned either way, with
+// an error describing the miss if the constraint was never met.
+func generateWithSimilarity(opts *genOptions, apiKey string, info ModelInfo, modelPath string, req ImageRequest) (*ImageResponse, float64, error) {
+	if opts.minSimilarity < 0 || opts.maxSimilarity > 1 || opts.minSimilarity > opts.maxSimilarity {
+		return nil, 0, errors.New("--min-similarity and --max-similarity must satisfy 0 <= min <= max <= 1")
+	}
+	if opts.similarityAttempts < 1 {
+		return nil, 0, errors.New("--similarity-attempts must be at least 1")
+	}
+
+	ref, _, err := decodeImageFile(opts.targetSimilarity)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read reference image: %w", err)
+	}
+	refHash := dHash(ref)
+
+	// Distance from the allowed range, used to pick the closest miss
+	distance := func(sim float64) float64 {
+		switch {
+		case sim < opts.minSimilarity:
+			return opts.minSimilarity - sim
+		case sim > opts.maxSimilarity:
+			return sim - opts.maxSimilarity
+		}
+		return 0
+	}
+
+	var best *ImageResponse
+	bestSim := 0.0
+	for attempt := 1; attempt <= opts.similarityAttempts; attempt++ {
+		if attempt > 1 {
+			// Keep runs reproducible when a seed was given
+			next := rand.IntN(1 << 31)
+			if req.Seed != nil {
+				next = *req.Seed + 1
+			}
+			req.Seed = &next
+		}
+
+		response, err := callFALAPI(opts.falClient(), apiKey, info, modelPath, req)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(response.Images) == 0 {
+			return nil, 0, errors.New("no images returned")
+		}
+
+		img, err := fetchImage(response.Images[0].URL)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to fetch result for comparison: %w", err)
+		}
+		sim := hashSimilarity(refHash, dHash(img))
+		infof("Attempt %d/%d (seed %d): similarity %.2f\n", attempt, opts.similarityAttempts, response.Seed, sim)
+
+		if best == nil || distance(sim) < distance(bestSim) {
+			best, bestSim = response, sim
+		}
+		if distance(sim) == 0 {
+			return response, sim, nil
+		}
+	}
+
+	return best, bestSim, fmt.Errorf("no result within similarity range [%.2f, %.2f] after %d attempt(s); keeping closest (%.2f)",
+		opts.minSimilarity, opts.maxSimilarity, opts.similarityAttempts, bestSim)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// sizesModel is the model `gen sizes` describes
+var sizesModel string
+
+// sizeOrder lists every ratio from widest to tallest, for display
+var sizeOrder = []string{"21:9", "16:9", "3:2", "4:3", "5:4", "1:1", "4:5", "3:4", "2:3", "9:16"}
+
+// runSizes prints the --size values a model accepts and what each one is
+// sent to the API as
+func runSizes(cmd *cobra.Command, args []string) {
+	name := resolveModel(sizesModel)
+	info, ok := models[name]
+	if !ok {
+		fatalf("unknown model '%s'. Use 'gen models' to see available options.", sizesModel)
+	}
+
+	fmt.Printf("Sizes for %s (sent as %s):\n\n", name, info.SizeParamName)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if info.SizeParamName == "aspect_ratio" {
+		for _, ratio := range sizeOrder {
+			if aspectRatioSupported[ratio] {
+				fmt.Fprintf(w, "  %s\t%s\n", ratio, ratio)
+			}
+		}
+		fmt.Fprintf(w, "  auto\tauto\n")
+		fmt.Fprintf(w, "  WIDTHxHEIGHT\tnearest ratio above\n")
+	} else {
+		for _, ratio := range sizeOrder {
+			switch sent := parseSize(ratio).(type) {
+			case string:
+				fmt.Fprintf(w, "  %s\t%s\n", ratio, sent)
+			case ImageSize:
+				fmt.Fprintf(w, "  %s\t%dx%d\n", ratio, sent.Width, sent.Height)
+			}
+		}
+		if info.SupportsAutoImgSize {
+			fmt.Fprintf(w, "  auto\tauto (edit mode), else the model's own default\n")
+		} else {
+			fmt.Fprintf(w, "  auto\tclosest ratio to the input image (edit mode), else the model's own default\n")
+		}
+		fmt.Fprintf(w, "  WIDTHxHEIGHT\tas given, each side %d-%d\n", minImageDimension, maxImageDimension)
+	}
+	w.Flush()
+	fmt.Printf("\nWithout --size, generations use %s\n", info.defaultSize())
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"text/tabwriter"
+)
+
+// checkSeedSweep rejects --seed-sweep combinations that would make the
+// seeds ambiguous or multiply the runs, before anything is paid for
+func checkSeedSweep(opts *genOptions) error {
+	switch {
+	case opts.seedSweep < 1:
+		return errors.New("--seed-sweep must be at least 1")
+	case opts.randomSeed:
+		return errors.New("--seed-sweep cannot be combined with --random-seed; every sweep image needs a known seed")
+	case opts.interactive || opts.watch || opts.thenPrompt != "":
+		return errors.New("--seed-sweep cannot be combined with --interactive, --watch, or --then")
+	case len(splitList(opts.size)) > 1:
+		return errors.New("--seed-sweep takes a single --size")
+	}
+	return nil
+}
+
+// sweepSeeds runs the prompt once per seed, counting up from the current
+// seed, and saves each result with the seed in its file name
+func sweepSeeds(opts *genOptions, apiKey, resolvedModel string, info ModelInfo, modelPath, prompt string, imageURLs []string) ([]*GenerationResult, error) {
+	first := opts.seed
+	if opts.dryRun {
+		infof("Seed sweep: %d runs with seeds %d-%d\n", opts.seedSweep, first, first+opts.seedSweep-1)
+		return nil, printDryRun(opts, info, opts.model, modelPath, prompt, []string{opts.size}, imageURLs)
+	}
+
+	var results []*GenerationResult
+	for i := range opts.seedSweep {
+		opts.seed = first + i
+		infof("\n[%d/%d] Seed %d\n", i+1, opts.seedSweep, opts.seed)
+		result, err := generateOne(opts, apiKey, resolvedModel, modelPath, prompt, opts.size, imageURLs, fmt.Sprintf("%s_seed%d", opts.outputSuffix, opts.seed))
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// printSweepTable lists each seed sweep output with the seed that made it
+func printSweepTable(results []*GenerationResult) {
+	if currentLogLevel < levelNormal {
+		return
+	}
+	fmt.Fprintln(msgOut)
+	w := tabwriter.NewWriter(msgOut, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SEED\tOUTPUT")
+	for _, result := range results {
+		for _, img := range result.Images {
+			output := img.URL
+			if len(img.Destinations) > 0 {
+				output = img.Destinations[0]
+			}
+			fmt.Fprintf(w, "%d\t%s\n", result.Seed, output)
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// checkThen rejects --then combinations that leave no single image to edit,
+// before anything is paid for
+func checkThen(opts *genOptions) error {
+	switch {
+	case opts.interactive:
+		return errors.New("--then cannot be combined with --interactive (use /last instead)")
+	case opts.numImages > 1 || len(splitList(opts.size)) > 1:
+		return errors.New("--then edits a single image; drop --num or the extra sizes")
+	case slices.Contains(opts.outputs, stdoutDest):
+		return errors.New("--then cannot be combined with -o - (both images would go to stdout)")
+	}
+	editModel := opts.model
+	if opts.thenModel != "" {
+		editModel = opts.thenModel
+	}
+	info, ok := models[resolveModel(editModel)]
+	if !ok {
+		return fmt.Errorf("unknown model '%s'. Use 'gen models' to see available options.", editModel)
+	}
+	if !info.canEdit() {
+		return fmt.Errorf("--then: model '%s' does not support editing (pick one that does with --then-model)", editModel)
+	}
+	return nil
+}
+
+// editResult runs the --then edit on the image just generated. Its outputs
+// get an _edit suffix so they don't overwrite the original, and its result
+// follows the generation's in the returned list.
+func editResult(opts *genOptions, apiKey string, results []*GenerationResult) ([]*GenerationResult, error) {
+	if len(results) == 0 {
+		if opts.dryRun {
+			infof("Skipping --then in a dry run: the edit needs the generated image\n")
+		}
+		return results, nil
+	}
+	generated := results[0].Images[0]
+
+	input := lastResultInput(generated)
+	if input == "" {
+		return results, errors.New("--then: the generated image has no local copy or URL to edit")
+	}
+
+	infof("\nEditing the result: %s\n", opts.thenPrompt)
+	opts.inputImages = []string{input}
+	opts.maskPath = ""
+	if opts.thenModel != "" {
+		opts.model = opts.thenModel
+	}
+	opts.outputSuffix = "_edit"
+	edited, err := generate(opts, apiKey, opts.thenPrompt)
+	if err != nil {
+		return results, fmt.Errorf("--then: %w", err)
+	}
+	results = append(results, edited...)
+
+	infof("\nGenerated: %s\n", input)
+	infof("Edited:    %s\n", lastResultInput(edited[0].Images[0]))
+	return results, nil
+}
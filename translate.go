@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// translateModelPath is the FAL LLM endpoint used for prompt translation
+const translateModelPath = "fal-ai/any-llm"
+
+const translateSystemPrompt = `You translate image generation prompts into English.
+Reply with only the translated prompt, without quotes or commentary.
+Keep @image references, HEX color codes, and proper nouns unchanged.
+If the prompt is already in English, reply with it unchanged.`
+
+// englishWords and foreignWords are function words that mark a prompt as
+// English or not. Words shared between languages, like "a", "in", or "die",
+// are left out of both.
+var englishWords = wordSet("the of with and for from by at are this that its his her their wearing under over into while")
+var foreignWords = wordSet("el la los las del con y una un por para sobre en " + // Spanish
+	"le les des du et avec une est dans sur au aux " + // French
+	"der das und mit ein eine im auf ist von zu " + // German
+	"il lo gli della di che nel sul " + // Italian
+	"os com em um uma do da " + // Portuguese
+	"het een van met op") // Dutch
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// Prompt languages as guessed by detectLanguage
+const (
+	langEnglish = iota
+	langForeign
+	langUncertain
+)
+
+// detectLanguage guesses whether a prompt is English. Mostly non-ASCII
+// letters mean another language; ASCII text is judged by which function
+// words it uses, and is uncertain when it has both kinds or neither.
+func detectLanguage(prompt string) int {
+	letters, nonASCII := 0, 0
+	for _, r := range prompt {
+		if unicode.IsLetter(r) {
+			letters++
+			if r > unicode.MaxASCII {
+				nonASCII++
+			}
+		}
+	}
+	if letters == 0 {
+		return langEnglish
+	}
+	if nonASCII*10 > letters {
+		return langForeign
+	}
+
+	english, foreign := 0, 0
+	words := strings.FieldsFunc(strings.ToLower(prompt), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, w := range words {
+		if englishWords[w] {
+			english++
+		}
+		if foreignWords[w] {
+			foreign++
+		}
+	}
+	switch {
+	case english > 0 && foreign == 0:
+		return langEnglish
+	case foreign > 0 && english == 0:
+		return langForeign
+	}
+	return langUncertain
+}
+
+func translationCachePath() string {
+	genDir := getGenCLIDir()
+	if genDir == "" {
+		return ""
+	}
+	return filepath.Join(genDir, "translations.json")
+}
+
+func loadTranslationCache() map[string]string {
+	cache := map[string]string{}
+	if path := translationCachePath(); path != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			_ = json.Unmarshal(data, &cache)
+		}
+	}
+	return cache
+}
+
+func saveTranslationCache(cache map[string]string) {
+	path := translationCachePath()
+	if path == "" {
+		return
+	}
+	if data, err := json.MarshalIndent(cache, "", "  "); err == nil {
+		_ = os.WriteFile(path, data, 0644)
+	}
+}
+
+// translatePrompt returns an English version of prompt, using the local
+// cache when the same prompt has been translated before. Prompts that are
+// plainly English are returned as they are without asking the model.
+func translatePrompt(opts *genOptions, apiKey, prompt string) (string, error) {
+	if detectLanguage(prompt) == langEnglish {
+		return prompt, nil
+	}
+
+	cache := loadTranslationCache()
+	if translated, ok := cache[prompt]; ok {
+		return translated, nil
+	}
+	if opts.dryRun {
+		infof("Dry run: the prompt would be translated first\n")
+		return prompt, nil
+	}
+
+	jsonData, err := json.Marshal(map[string]string{
+		"prompt":        prompt,
+		"system_prompt": translateSystemPrompt,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	client := opts.falClient()
+	body, err := postFAL(client, apiKey, fmt.Sprintf("%s/%s", client.baseURL, translateModelPath), jsonData)
+	if err != nil {
+		return "", fmt.Errorf("translation failed: %w", err)
+	}
+
+	var resp struct {
+		Output string `json:"output"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("failed to parse translation response: %w", err)
+	}
+	translated := strings.TrimSpace(resp.Output)
+	if translated == "" {
+		return "", errors.New("translation returned no text")
+	}
+
+	cache[prompt] = translated
+	saveTranslationCache(cache)
+	return translated, nil
+}
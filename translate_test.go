@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		prompt string
+		want   int
+	}{
+		{"a cat wearing a hat", langEnglish},
+		{"portrait of the queen in the style of Vermeer", langEnglish},
+		{"42 + 7 = 49", langEnglish},
+		{"un gato con sombrero en la playa", langForeign},
+		{"eine Katze mit Hut", langForeign},
+		{"猫が帽子をかぶっている", langForeign},
+		{"chat noir, photo", langUncertain},
+		{"el perro with the hat", langUncertain},
+	}
+	for _, tt := range tests {
+		if got := detectLanguage(tt.prompt); got != tt.want {
+			t.Errorf("detectLanguage(%q) = %d, want %d", tt.prompt, got, tt.want)
+		}
+	}
+}
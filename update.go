@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	latestReleaseURL    = "https://api.github.com/repos/cozy-creator/gen/releases/latest"
+	updateCheckInterval = 24 * time.Hour
+)
+
+// updateCheckState is cached in ~/.gen-cli/update-check.json so GitHub is
+// asked at most once a day
+type updateCheckState struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Latest    string    `json:"latest"`
+}
+
+// updateNotice receives the message to show when a newer release exists,
+// or "" when there's nothing to say. It's nil when no check was started.
+var updateNotice chan string
+
+// startUpdateCheck looks for a newer release in the background if the
+// config opts in with update_check and GEN_CLI_NO_UPDATE_CHECK isn't set
+func startUpdateCheck() {
+	if !config.UpdateCheck || os.Getenv("GEN_CLI_NO_UPDATE_CHECK") != "" {
+		return
+	}
+	current, ok := parseSemver(version)
+	if !ok {
+		return // Development builds have nothing to compare
+	}
+	genDir := getGenCLIDir()
+	if genDir == "" {
+		return
+	}
+
+	updateNotice = make(chan string, 1)
+	notify := func(latest string) {
+		notice := ""
+		if v, ok := parseSemver(latest); ok && semverLess(current, v) {
+			notice = fmt.Sprintf("A newer gen is available: %s (you have %s). Update with: go install github.com/cozy-creator/gen@latest", latest, version)
+		}
+		updateNotice <- notice
+	}
+
+	// A recent answer is reused straight away; only the request runs in the
+	// background
+	cachePath := filepath.Join(genDir, "update-check.json")
+	var state updateCheckState
+	if data, err := os.ReadFile(cachePath); err == nil {
+		if json.Unmarshal(data, &state) == nil && time.Since(state.CheckedAt) < updateCheckInterval {
+			notify(state.Latest)
+			return
+		}
+	}
+	go func() {
+		notify(fetchLatestRelease(cachePath))
+	}()
+}
+
+// printUpdateNotice shows the update notice if the check has finished;
+// gen never waits for it
+func printUpdateNotice() {
+	if updateNotice == nil || currentLogLevel == levelQuiet {
+		return
+	}
+	select {
+	case notice := <-updateNotice:
+		if notice != "" {
+			fmt.Fprintln(os.Stderr, notice)
+		}
+	default:
+	}
+}
+
+// fetchLatestRelease asks GitHub for the latest release tag and caches the
+// answer. Failures return "" and are otherwise ignored.
+func fetchLatestRelease(cachePath string) string {
+	resp, err := newHTTPClient(5 * time.Second).Get(latestReleaseURL)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	// Any answer, even "no releases", counts as today's check
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if resp.StatusCode == http.StatusOK {
+		_ = json.NewDecoder(resp.Body).Decode(&release)
+	}
+	state := updateCheckState{CheckedAt: time.Now(), Latest: release.TagName}
+	_ = writeJSONFile(cachePath, state)
+	return release.TagName
+}
+
+// parseSemver reads a release version like v1.2.3. Pre-releases and
+// pseudo-versions aren't considered releases.
+func parseSemver(v string) ([3]int, bool) {
+	var parts [3]int
+	fields := strings.Split(strings.TrimPrefix(v, "v"), ".")
+	if len(fields) != 3 {
+		return parts, false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+func semverLess(a, b [3]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
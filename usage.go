@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	usageSince string
+	usageBy    string
+)
+
+// usageRow totals the history entries in one group
+type usageRow struct {
+	key     string
+	runs    int
+	images  int
+	cost    float64
+	unknown bool // Some images had no price estimate
+}
+
+// runUsage totals generations from the history file by model or by day,
+// with estimated spend from the same prices as the cost confirmation
+func runUsage(cmd *cobra.Command, args []string) {
+	if usageBy != "model" && usageBy != "day" {
+		fatalf("invalid --by '%s' (valid: model, day)", usageBy)
+	}
+	var cutoff time.Time
+	if usageSince != "" {
+		age, err := parseAge(usageSince)
+		if err != nil {
+			fatalf("--since: %v", err)
+		}
+		cutoff = time.Now().Add(-age)
+	}
+
+	entries, err := readHistory()
+	if err != nil {
+		fatalf("failed to read history: %v", err)
+	}
+
+	groups := map[string]*usageRow{}
+	total := usageRow{key: "TOTAL"}
+	for _, entry := range entries {
+		if entry.CreatedAt.Before(cutoff) {
+			continue
+		}
+		key := entry.Model
+		if usageBy == "day" {
+			key = entry.CreatedAt.Local().Format("2006-01-02")
+		}
+		row := groups[key]
+		if row == nil {
+			row = &usageRow{key: key}
+			groups[key] = row
+		}
+
+		images := entry.images()
+		price := estimatedPrice(entry.Model)
+		for _, r := range []*usageRow{row, &total} {
+			r.runs++
+			r.images += images
+			r.cost += price * float64(images)
+			r.unknown = r.unknown || price == 0
+		}
+	}
+	if total.runs == 0 {
+		fmt.Println("No generations in history for this period")
+		return
+	}
+
+	rows := make([]*usageRow, 0, len(groups))
+	for _, row := range groups {
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].key < rows[j].key })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "MODEL"
+	if usageBy == "day" {
+		header = "DAY"
+	}
+	fmt.Fprintf(w, "%s\tRUNS\tIMAGES\tEST. COST\n", header)
+	for _, row := range append(rows, &total) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", row.key, row.runs, row.images, row.formatCost())
+	}
+	w.Flush()
+	if total.unknown {
+		fmt.Println("\n+ Some models have no price estimate; set one under prices in the config")
+	}
+}
+
+// formatCost renders the row's estimated spend, marking totals that leave
+// out models without a price
+func (r usageRow) formatCost() string {
+	switch {
+	case r.cost == 0 && r.unknown:
+		return "-"
+	case r.unknown:
+		return formatPrice(r.cost) + "+"
+	}
+	return formatPrice(r.cost)
+}
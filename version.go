@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// init fills in the version and commit from the module and VCS info Go
+// embeds when -ldflags didn't, so `go install ...@v1.2.3` builds still
+// report their version
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && commit == "" && len(setting.Value) >= 7 {
+			commit = setting.Value[:7]
+		}
+	}
+}
+
+// versionString describes the build on one line
+func versionString() string {
+	s := "gen " + version
+	if commit != "" {
+		s += " (commit " + commit
+		if buildDate != "" {
+			s += ", built " + buildDate
+		}
+		s += ")"
+	} else if buildDate != "" {
+		s += " (built " + buildDate + ")"
+	}
+	return fmt.Sprintf("%s, %s %s/%s", s, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
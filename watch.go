@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// watchInterval is how often --watch checks the prompt file
+	watchInterval = 500 * time.Millisecond
+	// watchDebounce is how long the file must stay unchanged before a run,
+	// so editors that save in several writes trigger only one
+	watchDebounce = 300 * time.Millisecond
+)
+
+// runWatch generates from the prompt file, then again each time it's saved
+// with a different prompt, until Ctrl-C. Results go to the same path every
+// time, named after the prompt file unless -o or --name-template says
+// otherwise, and the seed is kept so only prompt changes show.
+func runWatch(opts *genOptions, apiKey string) {
+	if len(opts.outputs) == 0 && opts.nameTemplate == "" {
+		opts.nameTemplate = strings.TrimSuffix(filepath.Base(opts.promptFile), filepath.Ext(opts.promptFile))
+	}
+
+	var lastMod time.Time
+	lastPrompt := ""
+	infof("Watching %s (Ctrl-C to stop)\n", opts.promptFile)
+	for {
+		if info, err := os.Stat(opts.promptFile); err == nil && !info.ModTime().Equal(lastMod) {
+			// Wait for the writes of a save to settle
+			for {
+				if err := sleepCtx(watchDebounce); err != nil {
+					return
+				}
+				settled, err := os.Stat(opts.promptFile)
+				if err != nil || settled.ModTime().Equal(info.ModTime()) {
+					break
+				}
+				info = settled
+			}
+			lastMod = info.ModTime()
+
+			prompt, err := readPromptFile(opts.promptFile)
+			switch {
+			case err != nil:
+				warnf("%v", err)
+			case prompt == lastPrompt:
+				debugf("Prompt unchanged; not regenerating\n")
+			default:
+				// A failed run is retried on the next save, even unchanged
+				if _, err := generate(opts, apiKey, prompt); err != nil {
+					if appCtx.Err() != nil {
+						return
+					}
+					warnf("%v", err)
+				} else {
+					lastPrompt = prompt
+				}
+				infof("\nWatching %s (Ctrl-C to stop)\n", opts.promptFile)
+			}
+		}
+		if err := sleepCtx(watchInterval); err != nil {
+			return
+		}
+	}
+}